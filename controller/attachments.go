@@ -0,0 +1,454 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/storage"
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxUploadBytes caps the size of a single attachment, from MAX_UPLOAD_BYTES
+// (default 25 MB)
+var maxUploadBytes = envInt64("MAX_UPLOAD_BYTES", 25<<20)
+
+// allowedUploadTypes lists the content types accepted for upload. Types are
+// sniffed from the file contents, not taken from the client.
+var allowedUploadTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+	"audio/mpeg":      true,
+	"audio/wave":      true,
+	"application/ogg": true,
+	"video/mp4":       true,
+	"video/webm":      true,
+}
+
+const (
+	// How long an upload may wait to be sent before it is swept, unless
+	// PENDING_UPLOAD_TTL is set
+	defaultPendingUploadTTL = 24 * time.Hour
+
+	// How often unsent uploads are swept, and how many per query
+	uploadSweepInterval = time.Hour
+	uploadSweepBatch    = 500
+)
+
+// envInt64 parses a positive integer environment variable, falling back to
+// def
+func envInt64(name string, def int64) int64 {
+	n, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// UploadAttachment stores a file sent as the "file" field of a multipart form
+// and returns its attachment record. The attachment is attached to a message
+// by passing its ID in attachment_ids when sending.
+func UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, userID, member, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+	if !requirePermission(w, member, permSendMessages) {
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, `{"error":"Expected a multipart/form-data body"}`, http.StatusBadRequest)
+		return
+	}
+
+	var part io.Reader
+	var fileName string
+	for {
+		p, err := reader.NextPart()
+		if err != nil {
+			if tooLarge(err) {
+				http.Error(w, `{"error":"File is too large"}`, http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, `{"error":"Missing file field"}`, http.StatusBadRequest)
+			}
+			return
+		}
+		if p.FormName() == "file" {
+			part, fileName = p, p.FileName()
+			break
+		}
+	}
+
+	// Sniff the type from the first bytes
+	buffered := bufio.NewReaderSize(part, 512)
+	head, _ := buffered.Peek(512)
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !allowedUploadTypes[mimeType] {
+		http.Error(w, fmt.Sprintf(`{"error":"File type %s is not allowed"}`, mimeType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	attachment, err := storeAttachment(chatID, userID, fileName, mimeType, buffered)
+	if err != nil {
+		if errors.Is(err, errFileTooLarge) || tooLarge(err) {
+			http.Error(w, `{"error":"File is too large"}`, http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, `{"error":"Failed to store file"}`, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+var errFileTooLarge = errors.New("file exceeds upload limit")
+
+// tooLarge reports whether err came from the request size limit
+func tooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// storeAttachment writes contents to the blob store and records them as a
+// pending attachment in chatID
+func storeAttachment(chatID, userID uint, fileName, mimeType string, contents io.Reader) (*models.Attachment, error) {
+	key, err := newStorageKey(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	size, err := storage.Default.Put(key, io.TeeReader(io.LimitReader(contents, maxUploadBytes+1), hash))
+	if err == nil && size > maxUploadBytes {
+		err = errFileTooLarge
+	}
+	if err != nil {
+		storage.Default.Delete(key)
+		return nil, err
+	}
+
+	attachment := models.Attachment{
+		ChatID:     chatID,
+		UploaderID: userID,
+		FileName:   cleanFileName(fileName),
+		MimeType:   mimeType,
+		Size:       size,
+		Checksum:   hex.EncodeToString(hash.Sum(nil)),
+		StorageKey: key,
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		attachment.Width, attachment.Height = imageSize(key)
+		if attachment.Width > 0 && attachment.Height > 0 {
+			attachment.ThumbnailStatus = models.ThumbnailPending
+		}
+	}
+	if voiceUploadTypes[mimeType] {
+		attachment.Codec, attachment.DurationMs, attachment.Waveform = probeVoice(key)
+	}
+
+	if err := database.DB.Create(&attachment).Error; err != nil {
+		storage.Default.Delete(key)
+		return nil, err
+	}
+	if attachment.ThumbnailStatus == models.ThumbnailPending {
+		queueThumbnails(attachment.ID)
+	}
+	return &attachment, nil
+}
+
+// newStorageKey returns a random, unguessable key for a new blob
+func newStorageKey(chatID uint) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("chats/%d/%s", chatID, hex.EncodeToString(b)), nil
+}
+
+// cleanFileName keeps only the base name of a client-supplied file name
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		return "file"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// imageSize reads an image's dimensions from its header, returning zeros for
+// formats that cannot be decoded
+func imageSize(key string) (int, int) {
+	f, err := storage.Default.Open(key)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// DownloadAttachment streams an attachment to a member of its chat. Pending
+// attachments are only visible to their uploader.
+func DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	// Replaced with the file's type once it is served
+	w.Header().Set("Content-Type", "application/json")
+
+	attachment, ok := authorizeAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	serveBlob(w, r, attachment.StorageKey, attachment.MimeType, attachment.Size, attachment.Checksum, attachment.FileName)
+}
+
+// authorizeAttachment loads the attachment named by the "id" path variable
+// and checks the caller may see it, writing an error response if not
+func authorizeAttachment(w http.ResponseWriter, r *http.Request) (*models.Attachment, bool) {
+	id, ok := pathID(w, r, "id", "attachment")
+	if !ok {
+		return nil, false
+	}
+
+	var attachment models.Attachment
+	if err := database.DB.First(&attachment, id).Error; err != nil {
+		http.Error(w, `{"error":"Attachment not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	userID, _, ok := authorizeChat(w, r, attachment.ChatID)
+	if !ok {
+		return nil, false
+	}
+	if attachment.MessageID == nil && attachment.UploaderID != userID {
+		http.Error(w, `{"error":"Attachment not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if attachment.MessageID != nil && hiddenFor(*attachment.MessageID, userID) {
+		http.Error(w, `{"error":"Attachment not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	return &attachment, true
+}
+
+// serveBlob writes a stored blob with caching and download headers
+func serveBlob(w http.ResponseWriter, r *http.Request, key, mimeType string, size int64, checksum, fileName string) {
+	etag := `"` + checksum + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := storage.Default.Open(key)
+	if err != nil {
+		http.Error(w, `{"error":"File is no longer available"}`, http.StatusNotFound)
+		return
+	}
+	defer blob.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, blob)
+}
+
+// pendingAttachments loads attachments the caller uploaded to chatID that are
+// not yet part of a message, failing if any ID does not qualify
+func pendingAttachments(ids []uint, chatID, userID uint) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+	if err := database.DB.
+		Where("id IN ? AND chat_id = ? AND uploader_id = ? AND message_id IS NULL", ids, chatID, userID).
+		Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	if len(attachments) != len(unique) {
+		return nil, errInvalidAttachments
+	}
+	return attachments, nil
+}
+
+var errInvalidAttachments = errors.New("attachment_ids must be your own unsent uploads to this chat")
+
+var errAttachmentsClaimed = errors.New("Some attachments were sent with another message")
+
+// claimAttachments links pending attachments to messageID inside tx. It fails
+// with errAttachmentsClaimed if a concurrent send took any of them first.
+func claimAttachments(tx *gorm.DB, attachments []models.Attachment, messageID uint) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(attachments))
+	for i, a := range attachments {
+		ids[i] = a.ID
+	}
+	result := tx.Model(&models.Attachment{}).
+		Where("id IN ? AND message_id IS NULL", ids).
+		Update("message_id", messageID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(ids)) {
+		return errAttachmentsClaimed
+	}
+	return nil
+}
+
+// attachmentMessageType picks the message type for a message carrying
+// attachments
+func attachmentMessageType(attachments []models.Attachment) string {
+	for _, a := range attachments {
+		if !strings.HasPrefix(a.MimeType, "image/") {
+			return "file"
+		}
+	}
+	return "image"
+}
+
+// StartUploadSweeper periodically deletes uploads that were never sent with
+// a message once they are older than PENDING_UPLOAD_TTL (default 24h). Call
+// once after InitDB and after storage.Default is set.
+func StartUploadSweeper() {
+	ttl := envDuration("PENDING_UPLOAD_TTL")
+	if ttl == 0 {
+		ttl = defaultPendingUploadTTL
+	}
+	go func() {
+		ticker := time.NewTicker(uploadSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			// Keep going while whole batches are being deleted
+			for sweepPendingUploads(now.Add(-ttl)) == uploadSweepBatch {
+				continue
+			}
+		}
+	}()
+}
+
+// sweepPendingUploads deletes up to uploadSweepBatch attachments uploaded
+// before cutoff that are still not part of a message, with their thumbnails
+// and blobs. It returns how many it deleted.
+func sweepPendingUploads(cutoff time.Time) int {
+	var stale []models.Attachment
+	if err := database.DB.Select("id", "storage_key").
+		Where("message_id IS NULL AND created_at < ?", cutoff).
+		Order("id ASC").
+		Limit(uploadSweepBatch).
+		Find(&stale).Error; err != nil {
+		log.Printf("Failed to look for unsent uploads: %v", err)
+		return 0
+	}
+
+	deleted := 0
+	for _, a := range stale {
+		var keys []string
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			// A send may claim the upload after it was listed
+			result := tx.Where("id = ? AND message_id IS NULL", a.ID).Delete(&models.Attachment{})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			keys = append(keys, a.StorageKey)
+			var thumbnailKeys []string
+			if err := tx.Model(&models.AttachmentThumbnail{}).Where("attachment_id = ?", a.ID).Pluck("storage_key", &thumbnailKeys).Error; err != nil {
+				return err
+			}
+			keys = append(keys, thumbnailKeys...)
+			return tx.Where("attachment_id = ?", a.ID).Delete(&models.AttachmentThumbnail{}).Error
+		})
+		if err != nil {
+			log.Printf("Failed to sweep upload %d: %v", a.ID, err)
+			continue
+		}
+		if len(keys) > 0 {
+			deleted++
+			deleteBlobs(keys)
+		}
+	}
+	return deleted
+}
+
+// deleteBlobs removes blobs whose rows are gone, or were never saved
+func deleteBlobs(keys []string) {
+	for _, key := range keys {
+		storage.Default.Delete(key)
+	}
+}
+
+// removeAttachments deletes a message's attachment and thumbnail rows,
+// returning their storage keys so the blobs can be removed once the
+// transaction commits
+func removeAttachments(tx *gorm.DB, messageID uint) ([]string, error) {
+	return removeAttachmentsWhere(tx, "message_id = ?", messageID)
+}
+
+// removeChatAttachments is removeAttachments for every attachment uploaded to
+// a chat, including uploads that were never sent
+func removeChatAttachments(tx *gorm.DB, chatID uint) ([]string, error) {
+	return removeAttachmentsWhere(tx, "chat_id = ?", chatID)
+}
+
+func removeAttachmentsWhere(tx *gorm.DB, query string, arg interface{}) ([]string, error) {
+	var keys, thumbnailKeys []string
+	attachmentIDs := tx.Model(&models.Attachment{}).Select("id").Where(query, arg)
+	if err := tx.Model(&models.AttachmentThumbnail{}).Where("attachment_id IN (?)", attachmentIDs).Pluck("storage_key", &thumbnailKeys).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("attachment_id IN (?)", attachmentIDs).Delete(&models.AttachmentThumbnail{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Attachment{}).Where(query, arg).Pluck("storage_key", &keys).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where(query, arg).Delete(&models.Attachment{}).Error; err != nil {
+		return nil, err
+	}
+	return append(keys, thumbnailKeys...), nil
+}
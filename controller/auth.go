@@ -134,15 +134,27 @@ type contextKey string
 
 const userIDKey contextKey = "user_id"
 
+// AuthMiddleware accepts the JWT only from the Authorization header
 func AuthMiddleware(next http.Handler) http.Handler {
+	return authenticate(next, false)
+}
+
+// StreamAuthMiddleware is AuthMiddleware for the WebSocket and SSE routes.
+// Browsers cannot set headers on those requests, so an "access_token" query
+// parameter is accepted as a fallback. It is limited to these routes to keep
+// tokens out of the access logs of every other request.
+func StreamAuthMiddleware(next http.Handler) http.Handler {
+	return authenticate(next, true)
+}
+
+func authenticate(next http.Handler, allowQueryToken bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		tokenStr, ok := tokenFromRequest(r, allowQueryToken)
+		if !ok {
 			http.Error(w, `{"error":"Missing or invalid token"}`, http.StatusUnauthorized)
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 			return jwtSecret, nil
 		})
@@ -169,3 +181,19 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// tokenFromRequest reads the bearer token from the Authorization header, or
+// from the "access_token" query parameter when allowQuery is set and no
+// header was sent
+func tokenFromRequest(r *http.Request, allowQuery bool) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer "), true
+	}
+	if authHeader == "" && allowQuery {
+		if token := r.URL.Query().Get("access_token"); token != "" {
+			return token, true
+		}
+	}
+	return "", false
+}
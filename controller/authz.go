@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Every chat, message and reaction handler authorizes through the policy
+// functions below so responses are consistent:
+//
+//	400 malformed ID, 401 no user in context, 404 chat or message does not
+//	exist (or the caller deleted the message for themselves), 403 caller is
+//	not a member of the chat.
+
+// currentUserID returns the authenticated user, writing 401 if missing
+func currentUserID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	userID, ok := r.Context().Value(userIDKey).(uint)
+	if !ok {
+		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+	}
+	return userID, ok
+}
+
+// pathID parses a positive numeric route variable, writing 400 if invalid
+func pathID(w http.ResponseWriter, r *http.Request, name, label string) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)[name], 10, 64)
+	if err != nil || id == 0 {
+		http.Error(w, `{"error":"Invalid `+label+` ID"}`, http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// chatMembership looks up userID's membership row in chatID
+func chatMembership(chatID, userID uint) (*models.ChatMember, error) {
+	var member models.ChatMember
+	if err := database.DB.Where("chat_id = ? AND user_id = ?", chatID, userID).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// isChatMember reports whether userID belongs to chatID
+func isChatMember(userID, chatID uint) bool {
+	var count int64
+	database.DB.Model(&models.ChatMember{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Count(&count)
+	return count > 0
+}
+
+// authorizeChat checks that the chat exists and the caller belongs to it
+func authorizeChat(w http.ResponseWriter, r *http.Request, chatID uint) (uint, *models.ChatMember, bool) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return 0, nil, false
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.Chat{}).Where("id = ?", chatID).Count(&count).Error; err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	if count == 0 {
+		http.Error(w, `{"error":"Chat not found"}`, http.StatusNotFound)
+		return 0, nil, false
+	}
+
+	member, err := chatMembership(chatID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, `{"error":"Not a member of this chat"}`, http.StatusForbidden)
+		return 0, nil, false
+	} else if err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	return userID, member, true
+}
+
+// authorizeChatParam is authorizeChat for the chat ID in route variable name
+func authorizeChatParam(w http.ResponseWriter, r *http.Request, name string) (uint, uint, *models.ChatMember, bool) {
+	chatID, ok := pathID(w, r, name, "chat")
+	if !ok {
+		return 0, 0, nil, false
+	}
+	userID, member, ok := authorizeChat(w, r, chatID)
+	return chatID, userID, member, ok
+}
+
+// authorizeMessage loads a message and checks the caller belongs to its chat
+// and has not deleted it for themselves
+func authorizeMessage(w http.ResponseWriter, r *http.Request, messageID uint) (uint, *models.Message, *models.ChatMember, bool) {
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return 0, nil, nil, false
+	}
+
+	var msg models.Message
+	if err := database.DB.First(&msg, messageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, `{"error":"Message not found"}`, http.StatusNotFound)
+		} else {
+			http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		}
+		return 0, nil, nil, false
+	}
+
+	member, err := chatMembership(msg.ChatID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, `{"error":"Not a member of this chat"}`, http.StatusForbidden)
+		return 0, nil, nil, false
+	} else if err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return 0, nil, nil, false
+	}
+	if hiddenFor(msg.ID, userID) {
+		http.Error(w, `{"error":"Message not found"}`, http.StatusNotFound)
+		return 0, nil, nil, false
+	}
+	return userID, &msg, member, true
+}
+
+// authorizeMessageParam is authorizeMessage for the message ID in route
+// variable name
+func authorizeMessageParam(w http.ResponseWriter, r *http.Request, name string) (uint, *models.Message, *models.ChatMember, bool) {
+	messageID, ok := pathID(w, r, name, "message")
+	if !ok {
+		return 0, nil, nil, false
+	}
+	return authorizeMessage(w, r, messageID)
+}
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Users in the authorization fixture
+const (
+	ownerID    uint = 1 // created the chat and sent its messages
+	memberID   uint = 2
+	outsiderID uint = 3
+
+	missingID uint = 999 // no chat or message has this ID
+)
+
+// authzFixture holds the rows a route test runs against
+type authzFixture struct {
+	chat       models.Chat
+	message    models.Message
+	attachment *models.Attachment // on message
+	voice      models.Message
+	poll       models.Message
+}
+
+// setupAuthzDB creates one group chat with an owner and a member, a message
+// from the owner with an attachment, a reaction and the member's receipt, a
+// pinned voice message and a poll, and a third user outside the chat
+func setupAuthzDB(t *testing.T) authzFixture {
+	t.Helper()
+	db := setupTestDB(t, "owner", "member", "outsider")
+
+	var f authzFixture
+	f.chat = createChat(t, db, ownerID, memberID)
+	db.Model(&models.ChatMember{}).Where("chat_id = ? AND user_id = ?", f.chat.ID, ownerID).Update("role", models.RoleOwner)
+	f.message = sendTestMessage(t, db, f.chat.ID, ownerID, "hello", time.Now())
+	if err := db.Create(&models.Reaction{MessageID: f.message.ID, UserID: ownerID, Emoji: "👍"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	f.voice = sendTestMessage(t, db, f.chat.ID, ownerID, "", time.Now())
+	if err := db.Model(&f.voice).Update("type", models.MessageTypeVoice).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.PinnedMessage{ChatID: f.chat.ID, MessageID: f.voice.ID, PinnedBy: ownerID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	f.poll = createPoll(t, db, f.chat.ID, false, nil)
+
+	var err error
+	f.attachment, err = storeAttachment(f.chat.ID, ownerID, "notes.txt", "text/plain", strings.NewReader("notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(f.attachment).Update("message_id", f.message.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// authzRoute describes one handler guarded by chat membership
+type authzRoute struct {
+	name    string
+	handler http.HandlerFunc
+	method  string
+	// route variable holding the target ID, or "" when the body names it
+	param string
+	// "chat", "message", "voice", "poll" or "attachment": what the ID refers to
+	target string
+	// request body; %[1]d is replaced by the target ID
+	body string
+	// the member making the request and the status they get
+	caller uint
+	status int
+}
+
+// serve calls route.handler as userID against targetID
+func (route authzRoute) serve(userID, targetID uint) int {
+	body := route.body
+	if strings.Contains(body, "%") {
+		body = fmt.Sprintf(body, targetID)
+	}
+	var vars map[string]string
+	if route.param != "" {
+		vars = map[string]string{route.param: fmt.Sprint(targetID)}
+	}
+	return call(route.handler, route.method, userID, vars, body).Code
+}
+
+// targetID returns the fixture row the route acts on
+func (route authzRoute) targetID(f authzFixture) uint {
+	switch route.target {
+	case "message":
+		return f.message.ID
+	case "voice":
+		return f.voice.ID
+	case "poll":
+		return f.poll.ID
+	case "attachment":
+		return f.attachment.ID
+	}
+	return f.chat.ID
+}
+
+// withVars adds fixed route variables, such as the user in a member route,
+// to the ones the test sets
+func withVars(handler http.HandlerFunc, extra map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := map[string]string{}
+		for k, v := range mux.Vars(r) {
+			vars[k] = v
+		}
+		for k, v := range extra {
+			vars[k] = v
+		}
+		handler(w, mux.SetURLVars(r, vars))
+	}
+}
+
+// asUpload sends the request body to handler as the file of a multipart
+// upload
+func asUpload(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		form := multipart.NewWriter(&buf)
+		part, _ := form.CreateFormFile("file", "upload.txt")
+		io.Copy(part, r.Body)
+		form.Close()
+		r.Body = io.NopCloser(&buf)
+		r.ContentLength = int64(buf.Len())
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		handler(w, r)
+	}
+}
+
+// runAuthzRoutes checks each route answers 403 to non-members, 404 for a
+// missing chat or message and its usual status to a member
+func runAuthzRoutes(t *testing.T, routes []authzRoute) {
+	for _, route := range routes {
+		t.Run(route.name, func(t *testing.T) {
+			tests := []struct {
+				name   string
+				userID uint
+				target func(authzFixture) uint
+				status int
+			}{
+				{"non-member", outsiderID, route.targetID, http.StatusForbidden},
+				{"missing", ownerID, func(authzFixture) uint { return missingID }, http.StatusNotFound},
+				{"member", route.caller, route.targetID, route.status},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					f := setupAuthzDB(t)
+					if got := route.serve(tt.userID, tt.target(f)); got != tt.status {
+						t.Fatalf("got %d, want %d", got, tt.status)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestChatRoutesRequireMembership(t *testing.T) {
+	runAuthzRoutes(t, []authzRoute{
+		{"GetChat", GetChat, "GET", "id", "chat", "", ownerID, http.StatusOK},
+		{"UpdateChat", UpdateChat, "PUT", "id", "chat", `{"name":"renamed"}`, ownerID, http.StatusOK},
+		{"DeleteChat", DeleteChat, "DELETE", "id", "chat", "", ownerID, http.StatusOK},
+		{"AddUserToGroupChat", AddUserToGroupChat, "POST", "chat_id", "chat", `{"user_ids":[3]}`, ownerID, http.StatusOK},
+		{"RemoveUserFromGroupChat", RemoveUserFromGroupChat, "DELETE", "chat_id", "chat", `{"user_ids":[2]}`, ownerID, http.StatusOK},
+		{"GetMessagesInChat", GetMessagesInChat, "GET", "chat_id", "chat", "", ownerID, http.StatusOK},
+		{"MarkChatRead", MarkChatRead, "PUT", "chat_id", "chat", "", memberID, http.StatusOK},
+		{"SendMultipleMessages", SendMultipleMessages, "POST", "chat_id", "chat", `[{"text":"one"},{"text":"two"}]`, ownerID, http.StatusCreated},
+		{"SearchMessagesInChat", SearchMessagesInChat, "POST", "chat_id", "chat", `{"text":"hello"}`, ownerID, http.StatusOK},
+		{"SetTyping", SetTyping, "POST", "chat_id", "chat", `{"state":"start"}`, ownerID, http.StatusNoContent},
+		{"GetChatPresence", GetChatPresence, "GET", "chat_id", "chat", "", ownerID, http.StatusOK},
+		{"GetPinnedMessages", GetPinnedMessages, "GET", "chat_id", "chat", "", memberID, http.StatusOK},
+	})
+}
+
+func TestMessageRoutesRequireMembership(t *testing.T) {
+	runAuthzRoutes(t, []authzRoute{
+		{"SendMessage", SendMessage, "POST", "", "chat", `{"chat_id":%[1]d,"text":"hi"}`, ownerID, http.StatusCreated},
+		{"GetMessage", GetMessage, "GET", "id", "message", "", ownerID, http.StatusOK},
+		{"UpdateMessage", UpdateMessage, "PUT", "id", "message", `{"text":"edited"}`, ownerID, http.StatusOK},
+		{"DeleteMessage", DeleteMessage, "DELETE", "id", "message", "", ownerID, http.StatusOK},
+		{"MarkDelivered", MarkDelivered, "PUT", "id", "message", "", memberID, http.StatusOK},
+		{"MarkRead", MarkRead, "PUT", "id", "message", "", memberID, http.StatusOK},
+		{"MarkPlayed", MarkPlayed, "PUT", "id", "voice", "", memberID, http.StatusOK},
+		{"CastVote", CastVote, "POST", "id", "poll", `{"option_ids":[]}`, memberID, http.StatusOK},
+		{"GetPollResults", GetPollResults, "GET", "id", "poll", "", memberID, http.StatusOK},
+		{"ForwardMessage", ForwardMessage, "POST", "id", "message", `{"chat_ids":[1]}`, ownerID, http.StatusCreated},
+		{"PinMessage", PinMessage, "POST", "id", "message", "", ownerID, http.StatusCreated},
+		{"UnpinMessage", UnpinMessage, "DELETE", "id", "voice", "", ownerID, http.StatusOK},
+		{"StarMessage", StarMessage, "POST", "id", "message", `{"note":"later"}`, memberID, http.StatusCreated},
+		{"AddOrUpdateReaction", AddOrUpdateReaction, "POST", "message_id", "message", `{"emoji":"🎉"}`, ownerID, http.StatusOK},
+		{"RemoveReaction", RemoveReaction, "DELETE", "message_id", "message", "", ownerID, http.StatusNoContent},
+		{"GetReactions", GetReactions, "GET", "message_id", "message", "", ownerID, http.StatusOK},
+		{"GetThread", GetThread, "GET", "id", "message", "", ownerID, http.StatusOK},
+		{"GetMessageHistory", GetMessageHistory, "GET", "id", "message", "", ownerID, http.StatusOK},
+	})
+}
+
+func TestMemberRoutesRequireMembership(t *testing.T) {
+	runAuthzRoutes(t, []authzRoute{
+		{"UpdateMemberRole", withVars(UpdateMemberRole, map[string]string{"user_id": "2"}), "PUT", "chat_id", "chat", `{"role":"admin"}`, ownerID, http.StatusOK},
+		{"LeaveChat", LeaveChat, "POST", "chat_id", "chat", "", memberID, http.StatusOK},
+		{"TransferOwnership", TransferOwnership, "POST", "chat_id", "chat", `{"user_id":2}`, ownerID, http.StatusOK},
+	})
+}
+
+func TestAttachmentRoutesRequireMembership(t *testing.T) {
+	runAuthzRoutes(t, []authzRoute{
+		{"UploadAttachment", asUpload(UploadAttachment), "POST", "chat_id", "chat", "some text", ownerID, http.StatusCreated},
+		{"DownloadAttachment", DownloadAttachment, "GET", "id", "attachment", "", memberID, http.StatusOK},
+	})
+}
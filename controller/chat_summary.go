@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Longest text kept in a message preview, in characters
+const previewLength = 100
+
+// chatActivity is the per-caller unread state of a chat
+type chatActivity struct {
+	UnreadCount    int64                  `json:"unread_count"`
+	UnreadMentions int64                  `json:"unread_mentions"`
+	LastMessage    *models.MessagePreview `json:"last_message_preview,omitempty"`
+}
+
+// chatSummary is a chat list entry: chat fields without history, plus the
+// caller's activity and optionally the member list
+type chatSummary struct {
+	ID            uint                `json:"id"`
+	Name          string              `json:"name,omitempty"`
+	Description   string              `json:"description,omitempty"`
+	IsGroup       bool                `json:"is_group"`
+	CreatedBy     uint                `json:"created_by"`
+	CreatedAt     time.Time           `json:"created_at"`
+	LastUpdatedAt *time.Time          `json:"last_updated_at,omitempty"`
+	MemberCount   int64               `json:"member_count"`
+	Members       []models.ChatMember `json:"members,omitempty"`
+	*chatActivity
+}
+
+// chatListCursor is the position after the last chat of a page
+type chatListCursor struct {
+	At time.Time `json:"t"`
+	ID uint      `json:"id"`
+}
+
+// newMessagePreview trims msg down to a preview
+func newMessagePreview(msg models.Message) *models.MessagePreview {
+	preview := &models.MessagePreview{
+		ID:        msg.ID,
+		SenderID:  msg.SenderID,
+		Text:      truncateText(displayText(msg), previewLength),
+		Type:      msg.Type,
+		CreatedAt: msg.CreatedAt,
+	}
+	if msg.Sender != nil {
+		preview.SenderName = msg.Sender.Name
+	}
+	return preview
+}
+
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "…"
+}
+
+// loadChatActivity computes unread counts, unread mentions and the last
+// message for each of chatIDs from userID's point of view, using a fixed
+// number of grouped queries rather than loading message history
+func loadChatActivity(userID uint, chatIDs []uint) (map[uint]*chatActivity, error) {
+	activity := make(map[uint]*chatActivity, len(chatIDs))
+	for _, id := range chatIDs {
+		activity[id] = &chatActivity{}
+	}
+	if len(chatIDs) == 0 {
+		return activity, nil
+	}
+
+	var user models.User
+	if err := database.DB.Select("id", "name").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	// Unread = the caller's status rows without read_at, above their
+	// read-up-to watermark
+	type unreadRow struct {
+		ChatID   uint
+		Unread   int64
+		Mentions int64
+	}
+	var rows []unreadRow
+	// Without a name every "@" would match, so nothing counts as a mention
+	mentions := gorm.Expr("0")
+	if name := strings.TrimSpace(user.Name); name != "" {
+		mentions = gorm.Expr("COALESCE(SUM(CASE WHEN LOWER(messages.text) LIKE ? THEN 1 ELSE 0 END), 0)",
+			"%@"+escapeLike(strings.ToLower(name))+"%")
+	}
+	if err := database.DB.Table("message_statuses").
+		Select("messages.chat_id AS chat_id, COUNT(*) AS unread, ? AS mentions", mentions).
+		Joins("JOIN messages ON messages.id = message_statuses.message_id AND messages.deleted_at IS NULL").
+		Joins("JOIN chat_members ON chat_members.chat_id = messages.chat_id AND chat_members.user_id = message_statuses.user_id").
+		Where("message_statuses.user_id = ? AND message_statuses.read_at IS NULL", userID).
+		Where("messages.chat_id IN ?", chatIDs).
+		Where("(chat_members.last_read_message_id IS NULL OR messages.id > chat_members.last_read_message_id)").
+		Where("messages.deleted_for_everyone_at IS NULL").
+		Scopes(visibleTo(userID)).
+		Group("messages.chat_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		activity[row.ChatID].UnreadCount = row.Unread
+		activity[row.ChatID].UnreadMentions = row.Mentions
+	}
+
+	// Last message per chat
+	var lastMessages []models.Message
+	if err := database.DB.Preload("Sender").
+		Where("id IN (?)", database.DB.Model(&models.Message{}).
+			Select("MAX(id)").
+			Scopes(visibleTo(userID)).
+			Where("chat_id IN ?", chatIDs).
+			Group("chat_id")).
+		Find(&lastMessages).Error; err != nil {
+		return nil, err
+	}
+	renderSystemMessages(lastMessages)
+	for _, msg := range lastMessages {
+		activity[msg.ChatID].LastMessage = newMessagePreview(msg)
+	}
+
+	return activity, nil
+}
+
+// escapeLike escapes LIKE wildcards in user-provided text
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
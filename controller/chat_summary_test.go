@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"testing"
+	"time"
+)
+
+func TestLoadChatActivityCountsUnread(t *testing.T) {
+	db := setupTestDB(t, "alice", "Bob")
+	chat := createChat(t, db, 1, 2)
+	quiet := createChat(t, db, 1, 2)
+	now := time.Now()
+
+	seen := sendTestMessage(t, db, chat.ID, 1, "before the watermark", now)
+	read := sendTestMessage(t, db, chat.ID, 1, "read on its own", now)
+	sendTestMessage(t, db, chat.ID, 1, "hi @bob", now)
+	sendTestMessage(t, db, chat.ID, 1, "lunch?", now)
+	deleted := sendTestMessage(t, db, chat.ID, 1, "@Bob oops", now)
+	sendTestMessage(t, db, chat.ID, 2, "my own message", now)
+
+	db.Model(&models.ChatMember{}).Where("chat_id = ? AND user_id = 2", chat.ID).Update("last_read_message_id", seen.ID)
+	db.Model(&models.MessageStatus{}).Where("message_id = ? AND user_id = 2", read.ID).Update("read_at", now)
+	db.Delete(&deleted)
+
+	activity, err := loadChatActivity(2, []uint{chat.ID, quiet.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := activity[chat.ID]
+	if got.UnreadCount != 2 || got.UnreadMentions != 1 {
+		t.Fatalf("unread %d, mentions %d; want 2 and 1", got.UnreadCount, got.UnreadMentions)
+	}
+	if got.LastMessage == nil || got.LastMessage.Text != "my own message" || got.LastMessage.SenderName != "Bob" {
+		t.Fatalf("last message preview %+v", got.LastMessage)
+	}
+	if q := activity[quiet.ID]; q.UnreadCount != 0 || q.LastMessage != nil {
+		t.Fatalf("empty chat has activity %+v", q)
+	}
+
+	// The sender sees none of their own messages as unread
+	activity, err = loadChatActivity(1, []uint{chat.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := activity[chat.ID]; got.UnreadCount != 1 || got.UnreadMentions != 0 {
+		t.Fatalf("sender: unread %d, mentions %d; want 1 and 0", got.UnreadCount, got.UnreadMentions)
+	}
+}
@@ -3,13 +3,12 @@ package controller
 import (
 	"ChatApiServer/database"
 	"ChatApiServer/models"
+	"ChatApiServer/realtime"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
-	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
@@ -39,9 +38,43 @@ func CreateChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for existing chat name (case-insensitive)
+	// Private chats are exactly two people and at most one per pair, so
+	// they go through the direct chat lookup instead
+	if !payload.IsGroup {
+		others := make(map[uint]bool)
+		for _, m := range payload.Members {
+			if m.UserID != userID {
+				others[m.UserID] = true
+			}
+		}
+		if len(others) != 1 {
+			http.Error(w, `{"error":"Private chats must have exactly two members"}`, http.StatusBadRequest)
+			return
+		}
+		var otherID uint
+		for id := range others {
+			otherID = id
+		}
+
+		chat, created, err := findOrCreateDirectChat(userID, otherID)
+		if !writeDirectChatError(w, err) {
+			return
+		}
+		status, message := http.StatusOK, "Private chat already exists"
+		if created {
+			status, message = http.StatusCreated, "Chat created successfully"
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": message,
+			"chat":    chat,
+		})
+		return
+	}
+
+	// Check for existing group name (case-insensitive)
 	var existingChat models.Chat
-	if err := database.DB.Where("LOWER(name) = LOWER(?)", payload.Name).First(&existingChat).Error; err == nil {
+	if err := database.DB.Where("is_group = ? AND LOWER(name) = LOWER(?)", true, payload.Name).First(&existingChat).Error; err == nil {
 		http.Error(w, `{"error":"Chat with this name already exists"}`, http.StatusConflict)
 		return
 	}
@@ -64,30 +97,34 @@ func CreateChat(w http.ResponseWriter, r *http.Request) {
 	uniqueMembers := make(map[uint]bool)
 	var filteredMembers []models.ChatMember
 
+	// The creator always joins as owner
+	filteredMembers = append(filteredMembers, models.ChatMember{
+		ChatID:   chat.ID,
+		UserID:   userID,
+		AddedBy:  &userID,
+		JoinedAt: time.Now(),
+		Role:     models.RoleOwner,
+	})
+	uniqueMembers[userID] = true
+
 	for _, m := range payload.Members {
 		if !uniqueMembers[m.UserID] {
+			// Other members may be given any role except owner
+			role := m.Role
+			if !validRole(role) || role == models.RoleOwner {
+				role = models.RoleMember
+			}
 			filteredMembers = append(filteredMembers, models.ChatMember{
 				ChatID:   chat.ID,
 				UserID:   m.UserID,
 				AddedBy:  &userID,
 				JoinedAt: time.Now(),
-				Role:     m.Role,
+				Role:     role,
 			})
 			uniqueMembers[m.UserID] = true
 		}
 	}
 
-	// Add creator if not already included
-	if !uniqueMembers[userID] {
-		filteredMembers = append(filteredMembers, models.ChatMember{
-			ChatID:   chat.ID,
-			UserID:   userID,
-			AddedBy:  &userID,
-			JoinedAt: time.Now(),
-			Role:     "admin",
-		})
-	}
-
 	// Save chat members
 	if err := database.DB.Create(&filteredMembers).Error; err != nil {
 		database.DB.Delete(&chat) // rollback chat
@@ -98,6 +135,12 @@ func CreateChat(w http.ResponseWriter, r *http.Request) {
 	// Attach members to chat for response
 	chat.Members = filteredMembers
 
+	postSystemMessage(chat.ID, models.SystemEvent{
+		Action:   models.SystemChatCreated,
+		ActorID:  userID,
+		NewValue: chat.Name,
+	})
+
 	// Return success response
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -108,10 +151,8 @@ func CreateChat(w http.ResponseWriter, r *http.Request) {
 
 // GetChat fetches chat details by ID
 func GetChat(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, `{"error":"Invalid chat ID"}`, http.StatusBadRequest)
+	id, _, _, ok := authorizeChatParam(w, r, "id")
+	if !ok {
 		return
 	}
 
@@ -135,11 +176,14 @@ func GetChat(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// / DeleteChat permanently deletes chat and all related data (messages, members)
+// / DeleteChat permanently deletes chat and all related data (messages, members).
+// Only the owner may delete a chat.
 func DeleteChat(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(mux.Vars(r)["id"])
-	if err != nil {
-		http.Error(w, `{"error":"Invalid chat ID"}`, http.StatusBadRequest)
+	id, _, member, ok := authorizeChatParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if !requirePermission(w, member, permDeleteChat) {
 		return
 	}
 
@@ -151,12 +195,30 @@ func DeleteChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Use a transaction for safety
-	err = database.DB.Transaction(func(tx *gorm.DB) error {
+	var blobs []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Delete attachments, keeping their keys to remove the files after commit
+		keys, err := removeChatAttachments(tx, chat.ID)
+		if err != nil {
+			return fmt.Errorf("failed to delete attachments: %v", err)
+		}
+		blobs = keys
+
+		// Delete polls with their options and votes
+		if err := removeChatPolls(tx, chat.ID); err != nil {
+			return fmt.Errorf("failed to delete polls: %v", err)
+		}
+
 		// Delete messages
 		if err := tx.Where("chat_id = ?", chat.ID).Delete(&models.Message{}).Error; err != nil {
 			return fmt.Errorf("failed to delete messages: %v", err)
 		}
 
+		// Delete pins
+		if err := tx.Where("chat_id = ?", chat.ID).Delete(&models.PinnedMessage{}).Error; err != nil {
+			return fmt.Errorf("failed to delete pins: %v", err)
+		}
+
 		// Delete members
 		if err := tx.Where("chat_id = ?", chat.ID).Delete(&models.ChatMember{}).Error; err != nil {
 			return fmt.Errorf("failed to remove chat members: %v", err)
@@ -174,6 +236,7 @@ func DeleteChat(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusInternalServerError)
 		return
 	}
+	deleteBlobs(blobs)
 
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
@@ -184,9 +247,8 @@ func DeleteChat(w http.ResponseWriter, r *http.Request) {
 
 // UpdateChat updates chat info like name or description
 func UpdateChat(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(mux.Vars(r)["id"])
-	if err != nil {
-		http.Error(w, `{"error":"Invalid chat ID"}`, http.StatusBadRequest)
+	id, userID, member, ok := authorizeChatParam(w, r, "id")
+	if !ok {
 		return
 	}
 
@@ -212,7 +274,16 @@ func UpdateChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Renaming and changing settings are separate permissions
+	if updatedData.Name != "" && !requirePermission(w, member, permRenameChat) {
+		return
+	}
+	if updatedData.Description != "" && !requirePermission(w, member, permChangeSettings) {
+		return
+	}
+
 	// Update only if data is provided
+	oldName, oldDescription := chat.Name, chat.Description
 	if updatedData.Name != "" {
 		chat.Name = updatedData.Name
 	}
@@ -226,6 +297,23 @@ func UpdateChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if chat.Name != oldName {
+		postSystemMessage(chat.ID, models.SystemEvent{
+			Action:   models.SystemChatRenamed,
+			ActorID:  userID,
+			OldValue: oldName,
+			NewValue: chat.Name,
+		})
+	}
+	if chat.Description != oldDescription {
+		postSystemMessage(chat.ID, models.SystemEvent{
+			Action:   models.SystemDescriptionChanged,
+			ActorID:  userID,
+			OldValue: oldDescription,
+			NewValue: chat.Description,
+		})
+	}
+
 	// Send success response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -235,22 +323,23 @@ func UpdateChat(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// AddUserToGroupChat adds members to a group chat
+// AddUserToGroupChat adds members to a group chat. The caller is recorded as
+// the one who added them and cannot grant a role above their own.
 func AddUserToGroupChat(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	chatIDStr := mux.Vars(r)["chat_id"]
-	chatID, err := strconv.Atoi(chatIDStr)
-	if err != nil {
-		http.Error(w, `{"error":"Invalid chat ID"}`, http.StatusBadRequest)
+	chatID, callerID, caller, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+	if !requirePermission(w, caller, permManageMembers) {
 		return
 	}
 
-	// Input includes user_ids, role, added_by
+	// Input includes user_ids and role
 	var input struct {
 		UserIDs []uint `json:"user_ids"`
-		Role    string `json:"role"`     // optional, default to "member"
-		AddedBy uint   `json:"added_by"` // who adds these users
+		Role    string `json:"role"` // optional, default to "member"
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, `{"error":"Invalid input data"}`, http.StatusBadRequest)
@@ -263,7 +352,15 @@ func AddUserToGroupChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if input.Role == "" {
-		input.Role = "member"
+		input.Role = models.RoleMember
+	}
+	if !validRole(input.Role) || input.Role == models.RoleOwner {
+		http.Error(w, `{"error":"role must be one of admin, moderator, member, read_only"}`, http.StatusBadRequest)
+		return
+	}
+	if roleRank[input.Role] > roleRank[memberRole(caller)] {
+		http.Error(w, `{"error":"You cannot grant a role above your own"}`, http.StatusForbidden)
+		return
 	}
 
 	// Check chat exists and is group
@@ -278,6 +375,7 @@ func AddUserToGroupChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add members
+	var added []uint
 	for _, userID := range input.UserIDs {
 		var existing models.ChatMember
 		err := database.DB.Where("chat_id = ? AND user_id = ?", chatID, userID).First(&existing).Error
@@ -287,15 +385,24 @@ func AddUserToGroupChat(w http.ResponseWriter, r *http.Request) {
 		}
 
 		member := models.ChatMember{
-			ChatID:  uint(chatID),
+			ChatID:  chatID,
 			UserID:  userID,
-			AddedBy: &input.AddedBy,
+			AddedBy: &callerID,
 			Role:    input.Role,
 		}
 		if err := database.DB.Create(&member).Error; err != nil {
 			http.Error(w, fmt.Sprintf(`{"error":"Failed to add user %d: %v"}`, userID, err), http.StatusInternalServerError)
 			return
 		}
+		realtime.SubscribeUser(userID, chatID)
+		added = append(added, userID)
+	}
+	if len(added) > 0 {
+		postSystemMessage(chatID, models.SystemEvent{
+			Action:    models.SystemMembersAdded,
+			ActorID:   callerID,
+			TargetIDs: added,
+		})
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -307,10 +414,12 @@ func AddUserToGroupChat(w http.ResponseWriter, r *http.Request) {
 func RemoveUserFromGroupChat(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Parse chat ID from the URL
-	chatID, err := strconv.Atoi(mux.Vars(r)["chat_id"])
-	if err != nil {
-		http.Error(w, `{"error":"Invalid chat ID"}`, http.StatusBadRequest)
+	// Parse chat ID from the URL and check the caller may manage members
+	chatID, callerID, caller, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+	if !requirePermission(w, caller, permManageMembers) {
 		return
 	}
 
@@ -340,16 +449,53 @@ func RemoveUserFromGroupChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Remove each user
-	for _, userID := range payload.UserIDs {
-		if err := database.DB.
-			Where("chat_id = ? AND user_id = ?", chat.ID, userID).
-			Delete(&models.ChatMember{}).Error; err != nil {
-			http.Error(w, `{"error":"Failed to remove some users"}`, http.StatusInternalServerError)
+	// Members can only remove members ranked below them
+	var targets []models.ChatMember
+	if err := database.DB.Where("chat_id = ? AND user_id IN ?", chat.ID, payload.UserIDs).Find(&targets).Error; err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	for _, target := range targets {
+		if !outranks(caller, memberRole(&target)) {
+			http.Error(w, fmt.Sprintf(`{"error":"You cannot remove user %d"}`, target.UserID), http.StatusForbidden)
 			return
 		}
 	}
 
+	// Remove each user, keeping the chat owned if data predating roles let
+	// the owner be removed
+	var successor *models.ChatMember
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockChat(tx, chat.ID); err != nil {
+			return err
+		}
+		for _, target := range targets {
+			if err := tx.Delete(&target).Error; err != nil {
+				return err
+			}
+		}
+		var err error
+		successor, err = ensureOwner(tx, chat.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, `{"error":"Failed to remove some users"}`, http.StatusInternalServerError)
+		return
+	}
+	removed := make([]uint, 0, len(targets))
+	for _, target := range targets {
+		realtime.UnsubscribeUser(target.UserID, chat.ID)
+		removed = append(removed, target.UserID)
+	}
+	if len(removed) > 0 {
+		postSystemMessage(chat.ID, models.SystemEvent{
+			Action:    models.SystemMembersRemoved,
+			ActorID:   callerID,
+			TargetIDs: removed,
+		})
+	}
+	announceOwner(chat.ID, callerID, successor)
+
 	// Success
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Users removed from group chat",
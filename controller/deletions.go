@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/storage"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Placeholder shown in place of a message deleted for everyone
+const deletedMessageText = "This message was deleted"
+
+// deleteWindow limits how long after sending senders can delete a message
+// for everyone, from MESSAGE_DELETE_WINDOW (e.g. "1h", default 48 hours).
+// "0" means no limit. Members with the delete_messages permission are not
+// limited.
+var deleteWindow = envDurationOr("MESSAGE_DELETE_WINDOW", 48*time.Hour)
+
+// visibleTo is a query scope that drops messages userID deleted for
+// themselves
+func visibleTo(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("messages.id NOT IN (?)", database.DB.Model(&models.HiddenMessage{}).
+			Select("message_id").
+			Where("user_id = ?", userID))
+	}
+}
+
+// hiddenFor reports whether userID deleted messageID for themselves
+func hiddenFor(messageID, userID uint) bool {
+	var count int64
+	database.DB.Model(&models.HiddenMessage{}).
+		Where("message_id = ? AND user_id = ?", messageID, userID).
+		Count(&count)
+	return count > 0
+}
+
+// displayText is the text shown for msg, substituting the placeholder for
+// messages deleted for everyone
+func displayText(msg models.Message) string {
+	if msg.DeletedForEveryoneAt != nil {
+		return deletedMessageText
+	}
+	return msg.Text
+}
+
+// hideMessage deletes a message for userID only
+func hideMessage(messageID, userID uint) error {
+	hidden := models.HiddenMessage{MessageID: messageID, UserID: userID}
+	return database.DB.Where(hidden).FirstOrCreate(&hidden).Error
+}
+
+// tombstoneMessage deletes a message for everyone: its text, attachments,
+// reactions, poll, pin, stars and edit history (including the edit count)
+// are removed, leaving a placeholder in the history
+func tombstoneMessage(msg *models.Message, deletedBy uint) error {
+	now := time.Now()
+	var blobKeys []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if blobKeys, err = removeAttachments(tx, msg.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&models.Reaction{}).Error; err != nil {
+			return err
+		}
+		if err := removePoll(tx, msg.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&models.PinnedMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&models.StarredMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", msg.ID).Delete(&models.MessageRevision{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(msg).Updates(map[string]interface{}{
+			"text":                    "",
+			"edited_at":               nil,
+			"edit_count":              0,
+			"deleted_for_everyone_at": now,
+			"deleted_by":              deletedBy,
+		}).Error
+	})
+	if err == nil {
+		for _, key := range blobKeys {
+			storage.Default.Delete(key)
+		}
+		msg.Text = ""
+		msg.EditedAt = nil
+		msg.EditCount = 0
+		msg.DeletedForEveryoneAt = &now
+		msg.DeletedBy = &deletedBy
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeleteForMeHidesMessageEverywhere(t *testing.T) {
+	db := setupTestDB(t, "alice", "bob")
+	chat := createChat(t, db, 1, 2)
+	parent := sendTestMessage(t, db, chat.ID, 1, "parent", time.Now())
+	vars := map[string]string{"id": fmt.Sprint(parent.ID)}
+
+	rec := call(withQuery(DeleteMessage, "scope=me"), "DELETE", 2, vars, "")
+	decodeBody(t, rec, http.StatusOK, nil)
+
+	// Every route that loads the message treats it as gone for bob
+	routes := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		param   string
+		body    string
+	}{
+		{"GetMessage", GetMessage, "GET", "id", ""},
+		{"GetThread", GetThread, "GET", "id", ""},
+		{"GetMessageHistory", GetMessageHistory, "GET", "id", ""},
+		{"AddOrUpdateReaction", AddOrUpdateReaction, "POST", "message_id", `{"emoji":"👍"}`},
+		{"MarkRead", MarkRead, "PUT", "id", ""},
+	}
+	for _, route := range routes {
+		t.Run(route.name, func(t *testing.T) {
+			target := map[string]string{route.param: fmt.Sprint(parent.ID)}
+			if rec := call(route.handler, route.method, 2, target, route.body); rec.Code != http.StatusNotFound {
+				t.Fatalf("hidden message: got %d, want 404: %s", rec.Code, rec.Body)
+			}
+			if route.name == "MarkRead" {
+				return // alice sent it, so she has no receipt to mark
+			}
+			if rec := call(route.handler, route.method, 1, target, route.body); rec.Code >= 300 {
+				t.Fatalf("sender lost the message: got %d: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	var page struct {
+		Messages []models.Message `json:"messages"`
+	}
+	rec = call(GetMessagesInChat, "GET", 2, map[string]string{"chat_id": fmt.Sprint(chat.ID)}, "")
+	decodeBody(t, rec, http.StatusOK, &page)
+	if len(page.Messages) != 0 {
+		t.Fatalf("hidden message listed: %+v", page.Messages)
+	}
+}
+
+func TestDeleteForEveryoneWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller uint // 1 sent the message; 2 holds role
+		role   string
+		age    time.Duration
+		status int
+	}{
+		{"sender inside the window", 1, models.RoleMember, time.Hour, http.StatusOK},
+		{"sender after the window", 1, models.RoleMember, 49 * time.Hour, http.StatusForbidden},
+		{"moderator after the window", 2, models.RoleModerator, 49 * time.Hour, http.StatusOK},
+		{"member deleting another's message", 2, models.RoleMember, time.Hour, http.StatusForbidden},
+	}
+	prev := deleteWindow
+	deleteWindow = 48 * time.Hour
+	defer func() { deleteWindow = prev }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, "alice", "bob")
+			chat := createChat(t, db, 1, 2)
+			setRole(t, chat.ID, 2, tt.role)
+			msg := sendTestMessage(t, db, chat.ID, 1, "secret", time.Now().Add(-tt.age))
+			db.Create(&models.MessageRevision{MessageID: msg.ID, Text: "draft", EditedBy: 1})
+
+			rec := call(DeleteMessage, "DELETE", tt.caller, map[string]string{"id": fmt.Sprint(msg.ID)}, "")
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			var got models.Message
+			db.First(&got, msg.ID)
+			if tt.status != http.StatusOK {
+				if got.DeletedForEveryoneAt != nil || got.Text != "secret" {
+					t.Fatal("message changed by a rejected delete")
+				}
+				return
+			}
+			if got.DeletedForEveryoneAt == nil || got.Text != "" || got.DeletedBy == nil || *got.DeletedBy != tt.caller {
+				t.Fatalf("not tombstoned: %+v", got)
+			}
+			var revisions int64
+			db.Model(&models.MessageRevision{}).Where("message_id = ?", msg.ID).Count(&revisions)
+			if revisions != 0 {
+				t.Fatal("edit history kept after deleting for everyone")
+			}
+		})
+	}
+}
+
+func TestDeleteWindowDefault(t *testing.T) {
+	t.Setenv("MESSAGE_DELETE_WINDOW", "")
+	if d := envDurationOr("MESSAGE_DELETE_WINDOW", 48*time.Hour); d != 48*time.Hour {
+		t.Fatalf("unset window = %v, want 48h", d)
+	}
+	t.Setenv("MESSAGE_DELETE_WINDOW", "0")
+	if d := envDurationOr("MESSAGE_DELETE_WINDOW", 48*time.Hour); d != 0 {
+		t.Fatalf(`"0" window = %v, want no limit`, d)
+	}
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GetOrCreateDirectChat returns the private chat between the caller and
+// another user, creating it on first use. Repeated calls from either side
+// always return the same chat.
+func GetOrCreateDirectChat(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	otherID, ok := pathID(w, r, "user_id", "user")
+	if !ok {
+		return
+	}
+
+	chat, created, err := findOrCreateDirectChat(userID, otherID)
+	if !writeDirectChatError(w, err) {
+		return
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chat":    chat,
+		"created": created,
+	})
+}
+
+var (
+	errDirectChatSelf = errors.New("cannot start a private chat with yourself")
+	errUserNotFound   = errors.New("user not found")
+)
+
+// writeDirectChatError maps findOrCreateDirectChat errors to responses,
+// returning true if err is nil
+func writeDirectChatError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errDirectChatSelf):
+		http.Error(w, `{"error":"Cannot start a private chat with yourself"}`, http.StatusBadRequest)
+	case errors.Is(err, errUserNotFound):
+		http.Error(w, `{"error":"User not found"}`, http.StatusNotFound)
+	default:
+		http.Error(w, `{"error":"Failed to open private chat"}`, http.StatusInternalServerError)
+	}
+	return false
+}
+
+// findOrCreateDirectChat looks up the private chat between userID and
+// otherID by its DirectKey, creating it (with userID as owner) if missing
+func findOrCreateDirectChat(userID, otherID uint) (models.Chat, bool, error) {
+	var chat models.Chat
+	if userID == otherID {
+		return chat, false, errDirectChatSelf
+	}
+
+	key := models.DirectChatKey(userID, otherID)
+	err := database.DB.Preload("Members").Where("direct_key = ?", key).First(&chat).Error
+	if err == nil {
+		return chat, false, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return chat, false, err
+	}
+
+	var other models.User
+	if err := database.DB.Select("id").First(&other, otherID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return chat, false, errUserNotFound
+		}
+		return chat, false, err
+	}
+
+	now := time.Now()
+	chat = models.Chat{
+		IsGroup:   false,
+		CreatedBy: userID,
+		CreatedAt: now,
+		DirectKey: &key,
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&chat).Error; err != nil {
+			return err
+		}
+		chat.Members = []models.ChatMember{
+			{ChatID: chat.ID, UserID: userID, AddedBy: &userID, JoinedAt: now, Role: models.RoleOwner},
+			{ChatID: chat.ID, UserID: otherID, AddedBy: &userID, JoinedAt: now, Role: models.RoleMember},
+		}
+		return tx.Create(&chat.Members).Error
+	})
+	if err != nil {
+		// Another request may have created the chat first; the unique
+		// DirectKey makes that insert fail, so return the winner's chat
+		var existing models.Chat
+		if database.DB.Preload("Members").Where("direct_key = ?", key).First(&existing).Error == nil {
+			return existing, false, nil
+		}
+		return models.Chat{}, false, err
+	}
+	realtime.SubscribeUser(userID, chat.ID)
+	realtime.SubscribeUser(otherID, chat.ID)
+	return chat, true, nil
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGetOrCreateDirectChatIsIdempotent(t *testing.T) {
+	db := setupTestDB(t, "alice", "bob")
+
+	open := func(caller, other uint, want int) uint {
+		t.Helper()
+		var resp struct {
+			Chat    models.Chat `json:"chat"`
+			Created bool        `json:"created"`
+		}
+		rec := call(GetOrCreateDirectChat, "POST", caller, map[string]string{"user_id": fmt.Sprint(other)}, "")
+		decodeBody(t, rec, want, &resp)
+		if resp.Created != (want == http.StatusCreated) {
+			t.Fatalf("created = %v with status %d", resp.Created, want)
+		}
+		return resp.Chat.ID
+	}
+
+	first := open(1, 2, http.StatusCreated)
+	if again := open(1, 2, http.StatusOK); again != first {
+		t.Fatalf("second call returned chat %d, want %d", again, first)
+	}
+	if reverse := open(2, 1, http.StatusOK); reverse != first {
+		t.Fatalf("other side got chat %d, want %d", reverse, first)
+	}
+
+	// Creating a private chat through CreateChat finds the same one
+	rec := call(CreateChat, "POST", 2, nil, `{"is_group":false,"members":[{"user_id":1}]}`)
+	var created struct {
+		Chat models.Chat `json:"chat"`
+	}
+	decodeBody(t, rec, http.StatusOK, &created)
+	if created.Chat.ID != first {
+		t.Fatalf("CreateChat returned chat %d, want %d", created.Chat.ID, first)
+	}
+
+	var count int64
+	db.Model(&models.Chat{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("%d chats exist, want 1", count)
+	}
+}
+
+func TestGetOrCreateDirectChatRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		other  uint
+		status int
+	}{
+		{"self", 1, http.StatusBadRequest},
+		{"unknown user", missingID, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t, "alice")
+			rec := call(GetOrCreateDirectChat, "POST", 1, map[string]string{"user_id": fmt.Sprint(tt.other)}, "")
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+// editWindow limits how long after sending a message can be edited, from
+// MESSAGE_EDIT_WINDOW (e.g. "15m"). Zero means no limit.
+var editWindow = envDuration("MESSAGE_EDIT_WINDOW")
+
+// envDuration parses a duration environment variable, returning zero if it
+// is unset or invalid
+func envDuration(name string) time.Duration {
+	return envDurationOr(name, 0)
+}
+
+// envDurationOr is envDuration with a fallback other than zero. Setting the
+// variable to "0" still gives zero.
+func envDurationOr(name string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d < 0 {
+		return fallback
+	}
+	return d
+}
+
+// GetMessageHistory returns a message's current text and every earlier
+// version, oldest first
+func GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, msg, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	revisions := []models.MessageRevision{}
+	if err := database.DB.Where("message_id = ?", msg.ID).Order("id ASC").Find(&revisions).Error; err != nil {
+		http.Error(w, `{"error":"Failed to load edit history"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": msg.ID,
+		"text":       msg.Text,
+		"edited_at":  msg.EditedAt,
+		"edit_count": msg.EditCount,
+		"revisions":  revisions,
+	})
+}
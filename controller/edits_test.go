@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEditHistory(t *testing.T) {
+	db := setupTestDB(t, "alice", "bob")
+	chat := createChat(t, db, 1, 2)
+	msg := sendTestMessage(t, db, chat.ID, 1, "v1", time.Now())
+	vars := map[string]string{"id": fmt.Sprint(msg.ID)}
+
+	for _, text := range []string{"v2", "v2", "v3"} {
+		rec := call(UpdateMessage, "PUT", 1, vars, fmt.Sprintf(`{"text":%q}`, text))
+		decodeBody(t, rec, http.StatusOK, nil)
+	}
+
+	// Resending the current text is not an edit
+	var history struct {
+		Text      string                   `json:"text"`
+		EditCount int                      `json:"edit_count"`
+		Revisions []models.MessageRevision `json:"revisions"`
+	}
+	decodeBody(t, call(GetMessageHistory, "GET", 2, vars, ""), http.StatusOK, &history)
+	if history.Text != "v3" || history.EditCount != 2 {
+		t.Fatalf("text %q after %d edits, want v3 after 2", history.Text, history.EditCount)
+	}
+	if len(history.Revisions) != 2 || history.Revisions[0].Text != "v1" || history.Revisions[1].Text != "v2" {
+		t.Fatalf("revisions %+v, want v1 then v2", history.Revisions)
+	}
+	if history.Revisions[0].EditedBy != 1 {
+		t.Fatalf("revision edited by %d", history.Revisions[0].EditedBy)
+	}
+}
+
+func TestUpdateMessageRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller uint
+		sentAt time.Duration // before now
+		system bool
+		status int
+	}{
+		{"another member's message", 2, 0, false, http.StatusForbidden},
+		{"system message", 1, 0, true, http.StatusBadRequest},
+		{"outside the edit window", 1, 2 * time.Hour, false, http.StatusForbidden},
+		{"inside the edit window", 1, time.Minute, false, http.StatusOK},
+	}
+	prev := editWindow
+	editWindow = time.Hour
+	defer func() { editWindow = prev }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, "alice", "bob")
+			chat := createChat(t, db, 1, 2)
+			msg := sendTestMessage(t, db, chat.ID, 1, "hello", time.Now().Add(-tt.sentAt))
+			if tt.system {
+				db.Model(&msg).Update("type", models.MessageTypeSystem)
+			}
+			rec := call(UpdateMessage, "PUT", tt.caller, map[string]string{"id": fmt.Sprint(msg.ID)}, `{"text":"edited"}`)
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var revisions int64
+			db.Model(&models.MessageRevision{}).Count(&revisions)
+			want := int64(0)
+			if tt.status == http.StatusOK {
+				want = 1
+			}
+			if revisions != want {
+				t.Fatalf("%d revisions stored, want %d", revisions, want)
+			}
+		})
+	}
+}
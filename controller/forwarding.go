@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"ChatApiServer/storage"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxForwardTargets caps the chats one message can be forwarded to at once
+const maxForwardTargets = 10
+
+// ForwardMessage copies a message, with its attachments, into each chat in
+// chat_ids. The caller must belong to the source chat and be allowed to post
+// in every target; nothing is forwarded unless all targets qualify.
+func ForwardMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, source, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+	switch {
+	case source.Type == models.MessageTypeSystem:
+		http.Error(w, `{"error":"System messages cannot be forwarded"}`, http.StatusBadRequest)
+		return
+	case source.Type == models.MessageTypePoll:
+		http.Error(w, `{"error":"Polls cannot be forwarded"}`, http.StatusBadRequest)
+		return
+	case source.DeletedForEveryoneAt != nil:
+		http.Error(w, `{"error":"Deleted messages cannot be forwarded"}`, http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		ChatIDs []uint `json:"chat_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"error":"Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	chatIDs := uniqueIDs(input.ChatIDs)
+	if len(chatIDs) == 0 || len(chatIDs) > maxForwardTargets {
+		http.Error(w, fmt.Sprintf(`{"error":"chat_ids must list between 1 and %d chats"}`, maxForwardTargets), http.StatusBadRequest)
+		return
+	}
+
+	// Check every target before forwarding to any
+	var chats []models.Chat
+	if err := database.DB.Preload("Members").Where("id IN ?", chatIDs).Find(&chats).Error; err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[uint]*models.Chat, len(chats))
+	for i := range chats {
+		byID[chats[i].ID] = &chats[i]
+	}
+	for _, chatID := range chatIDs {
+		chat, found := byID[chatID]
+		if !found {
+			http.Error(w, fmt.Sprintf(`{"error":"Chat %d not found"}`, chatID), http.StatusNotFound)
+			return
+		}
+		member := findMember(chat.Members, userID)
+		if member == nil {
+			http.Error(w, fmt.Sprintf(`{"error":"Not a member of chat %d"}`, chatID), http.StatusForbidden)
+			return
+		}
+		if !can(member, permSendMessages) {
+			http.Error(w, fmt.Sprintf(`{"error":"Your role in chat %d does not allow sending messages"}`, chatID), http.StatusForbidden)
+			return
+		}
+	}
+
+	var attachments []models.Attachment
+	if err := database.DB.Preload("Thumbnails").Where("message_id = ?", source.ID).Order("id ASC").Find(&attachments).Error; err != nil {
+		http.Error(w, `{"error":"Failed to load attachments"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Credit the original author, even when forwarding a forward
+	originalSender := source.SenderID
+	if source.ForwardedFromSenderID != nil {
+		originalSender = *source.ForwardedFromSenderID
+	}
+
+	// Each copy gets its own blobs, so deleting one chat's copy cannot
+	// remove the file from another chat
+	now := time.Now()
+	var blobKeys []string
+	messages := make([]models.Message, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		copies, keys, err := copyAttachments(attachments, chatID, userID)
+		blobKeys = append(blobKeys, keys...)
+		if err != nil {
+			deleteBlobs(blobKeys)
+			http.Error(w, `{"error":"Failed to copy attachments"}`, http.StatusInternalServerError)
+			return
+		}
+		messages = append(messages, models.Message{
+			ChatID:                 chatID,
+			SenderID:               userID,
+			Text:                   source.Text,
+			Type:                   source.Type,
+			CreatedAt:              now,
+			Attachments:            copies,
+			ForwardedFromMessageID: &source.ID,
+			ForwardedFromSenderID:  &originalSender,
+		})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Creates the copies' attachments and thumbnails along with them
+		if err := tx.Create(&messages).Error; err != nil {
+			return err
+		}
+
+		// Status records for the members of each target, like SendMessage
+		var statuses []models.MessageStatus
+		for _, msg := range messages {
+			for _, m := range byID[msg.ChatID].Members {
+				if m.UserID != userID {
+					statuses = append(statuses, models.MessageStatus{
+						MessageID:    msg.ID,
+						UserID:       m.UserID,
+						ChatMemberID: m.ID,
+						Status:       "sent",
+						SentAt:       &now,
+					})
+				}
+			}
+		}
+		if len(statuses) == 0 {
+			return nil
+		}
+		return tx.Create(&statuses).Error
+	})
+	if err != nil {
+		deleteBlobs(blobKeys)
+		http.Error(w, `{"error":"Failed to forward message"}`, http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]uint, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+		updateChatMetadata(msg.ChatID)
+		for _, a := range msg.Attachments {
+			if a.ThumbnailStatus == models.ThumbnailPending {
+				queueThumbnails(a.ID)
+			}
+		}
+	}
+
+	var forwarded []models.Message
+	if err := database.DB.
+		Preload("Sender").
+		Preload("StatusTrack").
+		Preload("Reactions").
+		Preload("Attachments.Thumbnails").
+		Where("id IN ?", ids).
+		Order("id ASC").
+		Find(&forwarded).Error; err != nil {
+		http.Error(w, `{"error":"Failed to fetch forwarded messages"}`, http.StatusInternalServerError)
+		return
+	}
+	decorateMessages(forwarded)
+	for _, msg := range forwarded {
+		publishChatEvent(msg.ChatID, realtime.MessageCreated, msg)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": forwarded,
+	})
+}
+
+// copyAttachments duplicates attachments and their thumbnails into chatID,
+// returning unsaved rows and the keys of the blobs written
+func copyAttachments(attachments []models.Attachment, chatID, userID uint) ([]models.Attachment, []string, error) {
+	var keys []string
+	copies := make([]models.Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		key, err := newStorageKey(chatID)
+		if err == nil {
+			err = copyBlob(a.StorageKey, key)
+		}
+		if err != nil {
+			return nil, keys, err
+		}
+		keys = append(keys, key)
+
+		c := a
+		c.ID = 0
+		c.MessageID = nil
+		c.ChatID = chatID
+		c.UploaderID = userID
+		c.StorageKey = key
+		c.CreatedAt = time.Time{}
+		c.Thumbnails = nil
+		if c.ThumbnailStatus == models.ThumbnailProcessing {
+			// The copy gets thumbnails of its own
+			c.ThumbnailStatus = models.ThumbnailPending
+		}
+		for _, t := range a.Thumbnails {
+			thumbKey := key + "_" + t.Size
+			if err := copyBlob(t.StorageKey, thumbKey); err != nil {
+				return nil, keys, err
+			}
+			keys = append(keys, thumbKey)
+			t.ID = 0
+			t.AttachmentID = 0
+			t.StorageKey = thumbKey
+			c.Thumbnails = append(c.Thumbnails, t)
+		}
+		copies = append(copies, c)
+	}
+	return copies, keys, nil
+}
+
+// copyBlob duplicates the blob stored under src to dst
+func copyBlob(src, dst string) error {
+	blob, err := storage.Default.Open(src)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	_, err = storage.Default.Put(dst, blob)
+	return err
+}
+
+// findMember returns userID's membership among members, or nil
+func findMember(members []models.ChatMember, userID uint) *models.ChatMember {
+	for i := range members {
+		if members[i].UserID == userID {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+// uniqueIDs drops zero and repeated IDs, keeping the first occurrence order
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	out := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
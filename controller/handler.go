@@ -20,6 +20,10 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
+
+// GetUserChats lists the caller's chats, most recently active first, as lean
+// summaries with member counts, unread counters and the last message. Results
+// are cursor-paginated (?limit=, ?cursor=); ?include=members adds member lists.
 func GetUserChats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -31,27 +35,115 @@ func GetUserChats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var chatMembers []models.ChatMember
-	if err := database.DB.Where("user_id = ?", userID).Find(&chatMembers).Error; err != nil {
-		http.Error(w, `{"error":"Failed to fetch chat memberships"}`, http.StatusInternalServerError)
-		return
-	}
+	limit := parseLimit(r, 20, 100)
+	includeMembers := r.URL.Query().Get("include") == "members"
 
-	var chatIDs []uint
-	for _, member := range chatMembers {
-		chatIDs = append(chatIDs, member.ChatID)
+	// Chats the user belongs to, ordered by activity (falling back to creation
+	// time for chats without messages) with ID as a tiebreaker
+	activityExpr := "COALESCE(chats.last_updated_at, chats.created_at)"
+	query := database.DB.Model(&models.Chat{}).
+		Select("chats.id", "chats.name", "chats.description", "chats.is_group", "chats.created_by",
+			"chats.created_at", "chats.last_message", "chats.last_updated_at").
+		Joins("JOIN chat_members ON chat_members.chat_id = chats.id AND chat_members.user_id = ?", userID).
+		Order(activityExpr + " DESC").
+		Order("chats.id DESC").
+		Limit(limit + 1)
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var pos chatListCursor
+		if err := decodeCursor(cursor, &pos); err != nil {
+			http.Error(w, `{"error":"Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+		query = query.Where("("+activityExpr+" < ? OR ("+activityExpr+" = ? AND chats.id < ?))", pos.At, pos.At, pos.ID)
 	}
 
 	var chats []models.Chat
-	if err := database.DB.Preload("Members").Preload("Messages").
-		Where("id IN ?", chatIDs).
-		Find(&chats).Error; err != nil {
+	if err := query.Find(&chats).Error; err != nil {
 		http.Error(w, `{"error":"Failed to fetch chats"}`, http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"user_id": userID,
-		"chats":   chats,
-	})
+	hasMore := len(chats) > limit
+	if hasMore {
+		chats = chats[:limit]
+	}
+
+	chatIDs := make([]uint, len(chats))
+	for i, chat := range chats {
+		chatIDs[i] = chat.ID
+	}
+
+	// Batch-load everything else for the page so the query count does not
+	// grow with the number of chats
+	activity, err := loadChatActivity(userID, chatIDs)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to compute unread counts"}`, http.StatusInternalServerError)
+		return
+	}
+
+	memberCounts := make(map[uint]int64, len(chatIDs))
+	members := make(map[uint][]models.ChatMember)
+	if len(chatIDs) > 0 {
+		var counts []struct {
+			ChatID uint
+			Count  int64
+		}
+		if err := database.DB.Model(&models.ChatMember{}).
+			Select("chat_id, COUNT(*) AS count").
+			Where("chat_id IN ?", chatIDs).
+			Group("chat_id").
+			Scan(&counts).Error; err != nil {
+			http.Error(w, `{"error":"Failed to count members"}`, http.StatusInternalServerError)
+			return
+		}
+		for _, c := range counts {
+			memberCounts[c.ChatID] = c.Count
+		}
+
+		if includeMembers {
+			var rows []models.ChatMember
+			if err := database.DB.Where("chat_id IN ?", chatIDs).Find(&rows).Error; err != nil {
+				http.Error(w, `{"error":"Failed to fetch members"}`, http.StatusInternalServerError)
+				return
+			}
+			for _, m := range rows {
+				members[m.ChatID] = append(members[m.ChatID], m)
+			}
+		}
+	}
+
+	summaries := make([]chatSummary, 0, len(chats))
+	for _, chat := range chats {
+		summary := chatSummary{
+			ID:            chat.ID,
+			Name:          chat.Name,
+			Description:   chat.Description,
+			IsGroup:       chat.IsGroup,
+			CreatedBy:     chat.CreatedBy,
+			CreatedAt:     chat.CreatedAt,
+			LastUpdatedAt: chat.LastUpdatedAt,
+			MemberCount:   memberCounts[chat.ID],
+			chatActivity:  activity[chat.ID],
+		}
+		if includeMembers {
+			summary.Members = members[chat.ID]
+		}
+		summaries = append(summaries, summary)
+	}
+
+	resp := map[string]interface{}{
+		"user_id":  userID,
+		"chats":    summaries,
+		"has_more": hasMore,
+	}
+	if hasMore {
+		last := chats[len(chats)-1]
+		at := last.CreatedAt
+		if last.LastUpdatedAt != nil {
+			at = *last.LastUpdatedAt
+		}
+		resp["next_cursor"] = encodeCursor(chatListCursor{At: at, ID: last.ID})
+	}
+	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errNotOwner aborts a transfer when ownership moved after the role check
+var errNotOwner = errors.New("not the owner")
+
+// LeaveChat removes the caller from a group chat. If the owner leaves, the
+// longest-tenured admin (or, failing that, member) becomes the new owner, and
+// when the last admin leaves the longest-tenured remaining member is made an
+// admin.
+func LeaveChat(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, userID, member, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+
+	var chat models.Chat
+	if err := database.DB.Select("id", "is_group").First(&chat, chatID).Error; err != nil {
+		http.Error(w, `{"error":"Chat not found"}`, http.StatusNotFound)
+		return
+	}
+	if !chat.IsGroup {
+		http.Error(w, `{"error":"Cannot leave a private chat"}`, http.StatusBadRequest)
+		return
+	}
+
+	var successor, admin *models.ChatMember
+	var adminWas string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockChat(tx, chatID); err != nil {
+			return err
+		}
+		// The role may have changed since the membership was checked
+		if err := tx.First(member, member.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		var err error
+		if successor, err = ensureOwner(tx, chatID); err != nil {
+			return err
+		}
+		if role := memberRole(member); role == models.RoleOwner || role == models.RoleAdmin {
+			admin, adminWas, err = ensureAdmin(tx, chatID)
+		}
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, `{"error":"Not a member of this chat"}`, http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error":"Failed to leave chat"}`, http.StatusInternalServerError)
+		return
+	}
+	realtime.UnsubscribeUser(userID, chatID)
+
+	postSystemMessage(chatID, models.SystemEvent{Action: models.SystemMemberLeft, ActorID: userID})
+	announceOwner(chatID, userID, successor)
+	announceAdmin(chatID, userID, admin, adminWas)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Left chat",
+	})
+}
+
+// TransferOwnership hands the chat to another member. The previous owner
+// stays in the chat as an admin.
+func TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, userID, member, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+	if memberRole(member) != models.RoleOwner {
+		http.Error(w, `{"error":"Only the owner can transfer ownership"}`, http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		UserID uint `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.UserID == 0 {
+		http.Error(w, `{"error":"user_id is required"}`, http.StatusBadRequest)
+		return
+	}
+	if input.UserID == userID {
+		http.Error(w, `{"error":"You already own this chat"}`, http.StatusBadRequest)
+		return
+	}
+
+	var target models.ChatMember
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockChat(tx, chatID); err != nil {
+			return err
+		}
+		// Another transfer may have completed since the role was checked
+		if err := tx.First(member, member.ID).Error; err != nil {
+			return err
+		}
+		if memberRole(member) != models.RoleOwner {
+			return errNotOwner
+		}
+		if err := tx.Where("chat_id = ? AND user_id = ?", chatID, input.UserID).First(&target).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(member).Update("role", models.RoleAdmin).Error; err != nil {
+			return err
+		}
+		return tx.Model(&target).Update("role", models.RoleOwner).Error
+	})
+	if errors.Is(err, errNotOwner) {
+		http.Error(w, `{"error":"Only the owner can transfer ownership"}`, http.StatusForbidden)
+		return
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, `{"error":"User is not a member of this chat"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error":"Failed to transfer ownership"}`, http.StatusInternalServerError)
+		return
+	}
+
+	publishChatEvent(chatID, realtime.MemberRoleUpdated, map[string]interface{}{
+		"user_id":    userID,
+		"role":       models.RoleAdmin,
+		"updated_by": userID,
+	})
+	announceOwner(chatID, userID, &target)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Ownership transferred",
+		"owner_id": target.UserID,
+	})
+}
+
+// lockChat locks the chat row until tx ends. Transactions that change a
+// chat's members or roles take it first, so checks such as "is anyone still
+// an admin" are not raced by a concurrent change.
+func lockChat(tx *gorm.DB, chatID uint) error {
+	var chat models.Chat
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&chat, chatID).Error
+}
+
+// ensureOwner promotes a remaining member to owner when a chat has none,
+// preferring the longest-tenured admin, then the longest-tenured member of
+// any other role. It returns the promoted member, or nil if no change was
+// needed or nobody is left.
+func ensureOwner(tx *gorm.DB, chatID uint) (*models.ChatMember, error) {
+	if err := lockChat(tx, chatID); err != nil {
+		return nil, err
+	}
+	var owners int64
+	if err := tx.Model(&models.ChatMember{}).
+		Where("chat_id = ? AND role = ?", chatID, models.RoleOwner).
+		Count(&owners).Error; err != nil {
+		return nil, err
+	}
+	if owners > 0 {
+		return nil, nil
+	}
+
+	var successor models.ChatMember
+	err := tx.Where("chat_id = ? AND role = ?", chatID, models.RoleAdmin).
+		Order("joined_at ASC, id ASC").
+		First(&successor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = tx.Where("chat_id = ?", chatID).
+			Order("joined_at ASC, id ASC").
+			First(&successor).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&successor).Update("role", models.RoleOwner).Error; err != nil {
+		return nil, err
+	}
+	return &successor, nil
+}
+
+// ensureAdmin promotes the longest-tenured member below admin to admin when
+// a chat has no admins left. It returns the promoted member and their former
+// role, or nil if no change was needed or nobody is left to promote.
+func ensureAdmin(tx *gorm.DB, chatID uint) (*models.ChatMember, string, error) {
+	if err := lockChat(tx, chatID); err != nil {
+		return nil, "", err
+	}
+	var admins int64
+	if err := tx.Model(&models.ChatMember{}).
+		Where("chat_id = ? AND role = ?", chatID, models.RoleAdmin).
+		Count(&admins).Error; err != nil {
+		return nil, "", err
+	}
+	if admins > 0 {
+		return nil, "", nil
+	}
+
+	var promoted models.ChatMember
+	err := tx.Where("chat_id = ? AND (role IS NULL OR role NOT IN ?)", chatID, []string{models.RoleOwner, models.RoleAdmin}).
+		Order("joined_at ASC, id ASC").
+		First(&promoted).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	oldRole := memberRole(&promoted)
+	if err := tx.Model(&promoted).Update("role", models.RoleAdmin).Error; err != nil {
+		return nil, "", err
+	}
+	return &promoted, oldRole, nil
+}
+
+// announceAdmin tells the chat that admin was promoted from oldRole. The
+// system message names no actor since nobody chose the promotion.
+func announceAdmin(chatID, actorID uint, admin *models.ChatMember, oldRole string) {
+	if admin == nil {
+		return
+	}
+	publishChatEvent(chatID, realtime.MemberRoleUpdated, map[string]interface{}{
+		"user_id":    admin.UserID,
+		"role":       models.RoleAdmin,
+		"updated_by": actorID,
+	})
+	postSystemMessage(chatID, models.SystemEvent{
+		Action:    models.SystemRoleChanged,
+		TargetIDs: []uint{admin.UserID},
+		OldValue:  oldRole,
+		NewValue:  models.RoleAdmin,
+	})
+}
+
+// announceOwner tells the chat that owner now owns it
+func announceOwner(chatID, actorID uint, owner *models.ChatMember) {
+	if owner == nil {
+		return
+	}
+	publishChatEvent(chatID, realtime.MemberRoleUpdated, map[string]interface{}{
+		"user_id":    owner.UserID,
+		"role":       models.RoleOwner,
+		"updated_by": actorID,
+	})
+	postSystemMessage(chatID, models.SystemEvent{
+		Action:    models.SystemOwnerChanged,
+		ActorID:   actorID,
+		TargetIDs: []uint{owner.UserID},
+	})
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestLeaveChatKeepsOwnerAndAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		leaver uint
+		role   string          // role of user 2
+		want   map[uint]string // roles of the remaining members
+	}{
+		{"owner leaves, admin takes over", 1, models.RoleAdmin, map[uint]string{2: models.RoleOwner, 3: models.RoleAdmin}},
+		{"owner leaves, oldest member takes over", 1, models.RoleModerator, map[uint]string{2: models.RoleOwner, 3: models.RoleAdmin}},
+		{"last admin leaves", 2, models.RoleAdmin, map[uint]string{1: models.RoleOwner, 3: models.RoleAdmin}},
+		{"member leaves", 3, models.RoleAdmin, map[uint]string{1: models.RoleOwner, 2: models.RoleAdmin}},
+		{"moderator leaves without an admin", 2, models.RoleModerator, map[uint]string{1: models.RoleOwner, 3: models.RoleMember}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat, _ := setupRoleChat(t, tt.role)
+			rec := call(LeaveChat, "POST", tt.leaver, map[string]string{"chat_id": fmt.Sprint(chat.ID)}, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", rec.Code, rec.Body)
+			}
+			if _, err := chatMembership(chat.ID, tt.leaver); err == nil {
+				t.Fatal("leaver is still a member")
+			}
+			for userID, role := range tt.want {
+				if got := memberRole(mustMember(t, chat.ID, userID)); got != role {
+					t.Errorf("user %d is %s, want %s", userID, got, role)
+				}
+			}
+		})
+	}
+}
+
+func TestTransferOwnership(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller uint
+		target uint
+		status int
+	}{
+		{"owner to member", 1, 3, http.StatusOK},
+		{"admin cannot transfer", 2, 3, http.StatusForbidden},
+		{"to a non-member", 1, 4, http.StatusNotFound},
+		{"to self", 1, 1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat, _ := setupRoleChat(t, models.RoleAdmin)
+			vars := map[string]string{"chat_id": fmt.Sprint(chat.ID)}
+			rec := call(TransferOwnership, "POST", tt.caller, vars, fmt.Sprintf(`{"user_id":%d}`, tt.target))
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if memberRole(mustMember(t, chat.ID, 1)) != models.RoleOwner {
+					t.Fatal("owner changed after a rejected transfer")
+				}
+				return
+			}
+			if memberRole(mustMember(t, chat.ID, tt.target)) != models.RoleOwner || memberRole(mustMember(t, chat.ID, tt.caller)) != models.RoleAdmin {
+				t.Fatal("roles not swapped")
+			}
+
+			// The previous owner cannot hand the chat on a second time
+			rec = call(TransferOwnership, "POST", tt.caller, vars, `{"user_id":2}`)
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("second transfer by the old owner: got %d", rec.Code)
+			}
+		})
+	}
+}
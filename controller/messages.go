@@ -3,14 +3,16 @@ package controller
 import (
 	"ChatApiServer/database"
 	"ChatApiServer/models"
+	"ChatApiServer/realtime"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gorilla/mux"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ✅ Utility function to update chat metadata
@@ -26,7 +28,7 @@ func updateChatMetadata(chatID uint) {
 		database.DB.Model(&models.Chat{}).
 			Where("id = ?", chatID).
 			Updates(map[string]interface{}{
-				"last_message":    lastMsg.Text,
+				"last_message":    displayText(lastMsg),
 				"last_updated_at": lastMsg.CreatedAt,
 			})
 	} else {
@@ -39,42 +41,79 @@ func updateChatMetadata(chatID uint) {
 			})
 	}
 }
+
+// reservedMessageType reports whether messages of type t are only created by
+// the server, so clients may not send them
+func reservedMessageType(t string) bool {
+	return t == models.MessageTypeSystem
+}
+
 func SendMessage(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		ChatID uint   `json:"chat_id"`
-		Text   string `json:"text"`
-		Type   string `json:"type"`
+		ChatID    uint   `json:"chat_id"`
+		Text      string `json:"text"`
+		Type      string `json:"type"`
+		ReplyToID *uint  `json:"reply_to_id"` // optional, starts or continues a thread
+
+		AttachmentIDs []uint     `json:"attachment_ids"` // uploads from POST /chats/{chat_id}/attachments
+		Poll          *pollInput `json:"poll"`           // required for type "poll"
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Get user ID from context
-	userIDAny := r.Context().Value(userIDKey)
-	userID, ok := userIDAny.(uint)
+	// Ensure sender is a member allowed to post
+	userID, member, ok := authorizeChat(w, r, input.ChatID)
 	if !ok {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
-
-	// Fetch chat with members
-	var chat models.Chat
-	if err := database.DB.Preload("Members").First(&chat, input.ChatID).Error; err != nil {
-		http.Error(w, "Chat not found", http.StatusNotFound)
+	if !requirePermission(w, member, permSendMessages) {
+		return
+	}
+	if reservedMessageType(input.Type) {
+		http.Error(w, `{"error":"Message type `+input.Type+` is reserved"}`, http.StatusBadRequest)
+		return
+	}
+	if input.ReplyToID != nil && !validReplyTarget(input.ChatID, *input.ReplyToID) {
+		http.Error(w, `{"error":"reply_to_id must reference a message in the same chat"}`, http.StatusBadRequest)
 		return
 	}
 
-	// Ensure sender is a member
-	isMember := false
-	for _, m := range chat.Members {
-		if m.UserID == userID {
-			isMember = true
-			break
+	attachments, err := pendingAttachments(input.AttachmentIDs, input.ChatID, userID)
+	if errors.Is(err, errInvalidAttachments) {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error":"Failed to load attachments"}`, http.StatusInternalServerError)
+		return
+	}
+	if input.Type == "" && len(attachments) > 0 {
+		input.Type = attachmentMessageType(attachments)
+	}
+	if input.Type == models.MessageTypeVoice {
+		if err := validateVoiceMessage(input.Text, attachments); err != nil {
+			http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	var poll *models.Poll
+	if input.Type == models.MessageTypePoll {
+		if len(attachments) > 0 {
+			http.Error(w, `{"error":"Poll messages cannot have attachments"}`, http.StatusBadRequest)
+			return
+		}
+		if poll, err = newPoll(input.Poll); err != nil {
+			http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+			return
 		}
+		input.Text = poll.Question
 	}
-	if !isMember {
-		http.Error(w, "Sender is not a member of this chat", http.StatusForbidden)
+
+	// Fetch chat with members
+	var chat models.Chat
+	if err := database.DB.Preload("Members").First(&chat, input.ChatID).Error; err != nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
 		return
 	}
 
@@ -85,37 +124,59 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
 		SenderID:  userID,
 		Text:      input.Text,
 		Type:      input.Type,
+		ReplyToID: input.ReplyToID,
 		CreatedAt: now,
 	}
 
-	// Save the message
-	if err := database.DB.Create(&msg).Error; err != nil {
-		http.Error(w, "Failed to save message", http.StatusInternalServerError)
-		return
-	}
+	// Save the message with its poll, claim its attachments and create status
+	// records for all other members together, so a failure leaves nothing
+	// behind
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&msg).Error; err != nil {
+			return err
+		}
 
-	// Create status records for all other members
-	var statuses []models.MessageStatus
-	for _, m := range chat.Members {
-		if m.UserID != userID {
-			statuses = append(statuses, models.MessageStatus{
-				MessageID:    msg.ID,
-				UserID:       m.UserID,
-				ChatMemberID: m.ID,
-				Status:       "sent",
-				SentAt:       &now,
-			})
+		if poll != nil {
+			poll.MessageID = msg.ID
+			if err := tx.Create(poll).Error; err != nil {
+				return err
+			}
 		}
-	}
-	if len(statuses) > 0 {
-		if err := database.DB.Create(&statuses).Error; err != nil {
-			http.Error(w, "Failed to insert message statuses", http.StatusInternalServerError)
-			return
+
+		if err := claimAttachments(tx, attachments, msg.ID); err != nil {
+			return err
 		}
+
+		var statuses []models.MessageStatus
+		for _, m := range chat.Members {
+			if m.UserID != userID {
+				statuses = append(statuses, models.MessageStatus{
+					MessageID:    msg.ID,
+					UserID:       m.UserID,
+					ChatMemberID: m.ID,
+					Status:       "sent",
+					SentAt:       &now,
+				})
+			}
+		}
+		if len(statuses) == 0 {
+			return nil
+		}
+		return tx.Create(&statuses).Error
+	})
+	if errors.Is(err, errAttachmentsClaimed) {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save message", http.StatusInternalServerError)
+		return
 	}
 
 	// Call metadata update function
 	updateChatMetadata(chat.ID)
+	if msg.ReplyToID != nil {
+		updateThreadStats(*msg.ReplyToID)
+	}
 
 	// Return enriched message
 	var fullMsg models.Message
@@ -123,62 +184,85 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
 		Preload("Sender").
 		Preload("StatusTrack").
 		Preload("Reactions").
+		Preload("Attachments.Thumbnails").
+		Scopes(withPoll).
 		First(&fullMsg, msg.ID).Error; err != nil {
 		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
 		return
 	}
 
+	decorated := []models.Message{fullMsg}
+	decorateMessages(decorated)
+	fullMsg = decorated[0]
+	clearTyping(fullMsg.ChatID, userID)
+	publishChatEvent(fullMsg.ChatID, realtime.MessageCreated, fullMsg)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(fullMsg)
 }
 
 func GetMessage(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(mux.Vars(r)["id"])
-	if err != nil {
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+	_, found, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
 		return
 	}
 
 	var msg models.Message
 	if err := database.DB.
-		Preload("ReplyTo"). // If you use ReplyTo
+		Preload("Sender").
 		Preload("Reactions").
 		Preload("StatusTrack").
-		First(&msg, id).Error; err != nil {
+		Preload("Attachments.Thumbnails").
+		Scopes(withPoll).
+		First(&msg, found.ID).Error; err != nil {
 		http.Error(w, "Message not found", http.StatusNotFound)
 		return
 	}
 
-	// Explicitly remove sender to omit it from JSON output
+	decorated := []models.Message{msg}
+	decorateMessages(decorated)
+	msg = decorated[0]
+
+	// Aggregate per-recipient receipts ("read by 3 of 5")
+	receipts, err := summarizeReceipts(msg)
+	if err != nil {
+		http.Error(w, "Failed to load receipts", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(msg)
+	json.NewEncoder(w).Encode(struct {
+		models.Message
+		Receipts receiptSummary `json:"receipts"`
+	}{msg, receipts})
 }
 
 func GetMessagesBetweenUsers(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(uint)
-	receiverID, _ := strconv.Atoi(mux.Vars(r)["chat_id"])
+	// The key includes the caller, so only their own chat can match
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	receiverID, ok := pathID(w, r, "chat_id", "user")
+	if !ok {
+		return
+	}
 
-	var chats []models.Chat
-	err := database.DB.
-		Joins("JOIN chat_members cm1 ON cm1.chat_id = chats.id").
-		Joins("JOIN chat_members cm2 ON cm2.chat_id = chats.id").
-		Where("cm1.user_id = ? AND cm2.user_id = ?", userID, receiverID).
-		Where("is_group = ?", false).
-		Group("chats.id").
-		Find(&chats).Error
-
-	if err != nil || len(chats) == 0 {
+	// Private chats are keyed by their pair of users
+	var chat models.Chat
+	if err := database.DB.Select("id").
+		Where("direct_key = ?", models.DirectChatKey(userID, receiverID)).
+		First(&chat).Error; err != nil {
 		http.Error(w, "No private chat found between users", http.StatusNotFound)
 		return
 	}
-
-	chatID := chats[0].ID
+	chatID := chat.ID
 
 	var messages []models.Message
 	if err := database.DB.
 		Preload("Sender").
+		Scopes(visibleTo(userID)).
 		Where("chat_id = ?", chatID).
 		Order("created_at ASC").
 		Find(&messages).Error; err != nil {
@@ -189,87 +273,78 @@ func GetMessagesBetweenUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(messages)
 }
-func MarkDelivered(w http.ResponseWriter, r *http.Request) {
-	messageID, err := strconv.Atoi(mux.Vars(r)["id"])
-	if err != nil {
-		http.Error(w, `{"error":"Invalid message ID"}`, http.StatusBadRequest)
-		return
-	}
-
-	now := time.Now()
-	result := database.DB.Model(&models.MessageStatus{}).
-		Where("message_id = ?", messageID).
-		Updates(map[string]interface{}{
-			"delivered_at": now,
-			"status":       "delivered",
-		})
 
-	if result.Error != nil {
-		http.Error(w, `{"error":"Failed to update delivery status"}`, http.StatusInternalServerError)
-		return
-	}
-	if result.RowsAffected == 0 {
-		http.Error(w, `{"error":"No delivery status found for this message"}`, http.StatusNotFound)
-		return
-	}
-
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Marked as delivered",
-	})
+// MarkDelivered marks a message as delivered for the caller only
+func MarkDelivered(w http.ResponseWriter, r *http.Request) {
+	markReceipt(w, r, "delivered")
 }
 
+// MarkRead marks a message as read for the caller only
 func MarkRead(w http.ResponseWriter, r *http.Request) {
-	messageID, err := strconv.Atoi(mux.Vars(r)["id"])
-	if err != nil {
-		http.Error(w, `{"error":"Invalid message ID"}`, http.StatusBadRequest)
-		return
-	}
-
-	now := time.Now()
-	result := database.DB.Model(&models.MessageStatus{}).
-		Where("message_id = ?", messageID).
-		Updates(map[string]interface{}{
-			"read_at": now,
-			"status":  "read",
-		})
-
-	if result.Error != nil {
-		http.Error(w, `{"error":"Failed to update read status"}`, http.StatusInternalServerError)
-		return
-	}
-	if result.RowsAffected == 0 {
-		http.Error(w, `{"error":"No read status found for this message"}`, http.StatusNotFound)
-		return
-	}
+	markReceipt(w, r, "read")
+}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Marked as read",
-	})
+// MarkPlayed marks a voice message as played (and read) for the caller only
+func MarkPlayed(w http.ResponseWriter, r *http.Request) {
+	markReceipt(w, r, "played")
 }
 
+// DeleteMessage deletes a message. ?scope=everyone (the default) replaces it
+// with a "message deleted" tombstone for all members and is open to the
+// sender, within MESSAGE_DELETE_WINDOW, and to members allowed to delete
+// others' messages. ?scope=me hides it from the caller only.
 func DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	idStr := mux.Vars(r)["id"]
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, `{"error":"Invalid message ID"}`, http.StatusBadRequest)
+	userID, msg, member, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
 		return
 	}
 
-	var msg models.Message
-	if err := database.DB.First(&msg, id).Error; err != nil {
-		http.Error(w, `{"error":"Message not found"}`, http.StatusNotFound)
+	switch r.URL.Query().Get("scope") {
+	case "me":
+		if err := hideMessage(msg.ID, userID); err != nil {
+			http.Error(w, `{"error":"Failed to delete message"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Message deleted for you",
+		})
+		return
+	case "", "everyone":
+	default:
+		http.Error(w, `{"error":"scope must be 'everyone' or 'me'"}`, http.StatusBadRequest)
 		return
 	}
 
-	if err := database.DB.Delete(&msg).Error; err != nil {
-		http.Error(w, `{"error":"Failed to delete message"}`, http.StatusInternalServerError)
+	if msg.Type == models.MessageTypeSystem {
+		http.Error(w, `{"error":"System messages can only be deleted for yourself"}`, http.StatusBadRequest)
 		return
 	}
+	if msg.SenderID != userID {
+		if !requirePermission(w, member, permDeleteMessages) {
+			return
+		}
+	} else if deleteWindow > 0 && time.Since(msg.CreatedAt) > deleteWindow && !can(member, permDeleteMessages) {
+		http.Error(w, `{"error":"This message can no longer be deleted for everyone"}`, http.StatusForbidden)
+		return
+	}
+
+	if msg.DeletedForEveryoneAt == nil {
+		if err := tombstoneMessage(msg, userID); err != nil {
+			http.Error(w, `{"error":"Failed to delete message"}`, http.StatusInternalServerError)
+			return
+		}
 
-	// Call your chat metadata update function after deletion
-	updateChatMetadata(msg.ChatID)
+		// Call your chat metadata update function after deletion
+		updateChatMetadata(msg.ChatID)
+
+		publishChatEvent(msg.ChatID, realtime.MessageDeleted, map[string]interface{}{
+			"message_id":              msg.ID,
+			"deleted_by":              userID,
+			"deleted_for_everyone_at": msg.DeletedForEveryoneAt,
+		})
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -277,14 +352,36 @@ func DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// UpdateMessage edits the text of the caller's own message, keeping the
+// previous text as a revision. Edits may be limited to MESSAGE_EDIT_WINDOW
+// after sending.
 func UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Get message ID from URL
-	msgIDStr := mux.Vars(r)["id"]
-	msgID, err := strconv.Atoi(msgIDStr)
-	if err != nil {
-		http.Error(w, `{"error":"Invalid message ID"}`, http.StatusBadRequest)
+	// Find the message and check the caller belongs to its chat
+	userID, msg, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if msg.Type == models.MessageTypeSystem {
+		http.Error(w, `{"error":"System messages cannot be edited"}`, http.StatusBadRequest)
+		return
+	}
+	if msg.DeletedForEveryoneAt != nil {
+		http.Error(w, `{"error":"Deleted messages cannot be edited"}`, http.StatusBadRequest)
+		return
+	}
+	if msg.Type == models.MessageTypePoll {
+		http.Error(w, `{"error":"Polls cannot be edited"}`, http.StatusBadRequest)
+		return
+	}
+	if msg.SenderID != userID {
+		http.Error(w, `{"error":"Only the sender can edit a message"}`, http.StatusForbidden)
+		return
+	}
+	if editWindow > 0 && time.Since(msg.CreatedAt) > editWindow {
+		http.Error(w, `{"error":"This message can no longer be edited"}`, http.StatusForbidden)
 		return
 	}
 
@@ -297,87 +394,259 @@ func UpdateMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find the message
-	var msg models.Message
-	if err := database.DB.First(&msg, msgID).Error; err != nil {
+	// Re-read the message under a row lock so concurrent edits each record
+	// the text they replaced
+	changed := false
+	now := time.Now()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, msg.ID).Error; err != nil {
+			return err
+		}
+		if current.Text == input.Text {
+			return nil
+		}
+		if err := tx.Create(&models.MessageRevision{
+			MessageID: current.ID,
+			Text:      current.Text,
+			EditedBy:  userID,
+			CreatedAt: now,
+		}).Error; err != nil {
+			return err
+		}
+		changed = true
+		return tx.Model(&current).Updates(map[string]interface{}{
+			"text":       input.Text,
+			"edited_at":  now,
+			"edit_count": gorm.Expr("edit_count + 1"),
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		http.Error(w, `{"error":"Message not found"}`, http.StatusNotFound)
 		return
+	} else if err != nil {
+		http.Error(w, `{"error":"Failed to update message"}`, http.StatusInternalServerError)
+		return
+	}
+	if changed {
+		updateChatMetadata(msg.ChatID)
 	}
 
-	// Update and save
-	msg.Text = input.Text
-	if err := database.DB.Save(&msg).Error; err != nil {
-		http.Error(w, `{"error":"Failed to update message"}`, http.StatusInternalServerError)
+	var updated models.Message
+	if err := database.DB.Preload("Sender").Preload("Reactions").Preload("Attachments.Thumbnails").Scopes(withPoll).First(&updated, msg.ID).Error; err != nil {
+		http.Error(w, `{"error":"Failed to load message"}`, http.StatusInternalServerError)
 		return
 	}
+	decorated := []models.Message{updated}
+	decorateMessages(decorated)
+	updated = decorated[0]
+
+	publishChatEvent(updated.ChatID, realtime.MessageUpdated, updated)
 
 	// Respond with success
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":      true,
 		"message":      "Message updated successfully",
-		"message_id":   msg.ID,
-		"updated_text": msg.Text,
+		"message_id":   updated.ID,
+		"updated_text": updated.Text,
+		"edited_at":    updated.EditedAt,
+		"edit_count":   updated.EditCount,
 	})
 }
 
+// GetMessagesInChat pages through a chat's history by message ID anchors:
+// ?before=<id> loads older messages, ?after=<id> newer ones and ?around=<id>
+// centres the page on a message (e.g. a search hit). Without an anchor the
+// newest messages are returned. Messages are always in chronological order,
+// and the opaque prev_cursor / next_cursor can be passed back as ?cursor=.
 func GetMessagesInChat(w http.ResponseWriter, r *http.Request) {
-	chatIDStr := mux.Vars(r)["chat_id"]
-	chatID, err := strconv.Atoi(chatIDStr)
-	if err != nil || chatID <= 0 {
-		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+	chatID, userID, _, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
 		return
 	}
 
-	pageStr := r.URL.Query().Get("page")
-	page := 1
-	if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-		page = p
+	query := r.URL.Query()
+	limit := parseLimit(r, 50, 200)
+
+	// Resolve the anchor from an opaque cursor or the raw query parameters
+	var anchor messageCursor
+	if cursor := query.Get("cursor"); cursor != "" {
+		if err := decodeCursor(cursor, &anchor); err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	} else {
+		for name, dst := range map[string]*uint{"before": &anchor.Before, "after": &anchor.After, "around": &anchor.Around} {
+			if v := query.Get(name); v != "" {
+				id, err := strconv.ParseUint(v, 10, 64)
+				if err != nil || id == 0 {
+					http.Error(w, "Invalid "+name+" message ID", http.StatusBadRequest)
+					return
+				}
+				*dst = uint(id)
+			}
+		}
+	}
+	if anchor.count() > 1 {
+		http.Error(w, "Only one of before, after or around may be given", http.StatusBadRequest)
+		return
 	}
 
-	limit := 100
-	offset := (page - 1) * limit
+	base := func() *gorm.DB {
+		return database.DB.
+			Preload("Sender").
+			Preload("StatusTrack").
+			Preload("Reactions").
+			Preload("Attachments.Thumbnails").
+			Scopes(withPoll).
+			Scopes(visibleTo(userID)).
+			Where("chat_id = ?", chatID)
+	}
 
 	var messages []models.Message
+	var hasOlder, hasNewer bool
 
-	if err := database.DB.
-		Preload("Sender").
-		Preload("StatusTrack").
-		Preload("Reactions").
-		Where("chat_id = ?", chatID).
-		Order("created_at ASC").
-		Limit(limit).
-		Offset(offset).
-		Find(&messages).Error; err != nil {
-		http.Error(w, "Failed to load messages", http.StatusInternalServerError)
-		return
+	switch {
+	case anchor.After != 0:
+		if err := base().Where("id > ?", anchor.After).Order("id ASC").Limit(limit + 1).Find(&messages).Error; err != nil {
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+		hasNewer = len(messages) > limit
+		if hasNewer {
+			messages = messages[:limit]
+		}
+
+	case anchor.Around != 0:
+		var target models.Message
+		if err := database.DB.Select("id").Where("chat_id = ?", chatID).First(&target, anchor.Around).Error; err != nil {
+			http.Error(w, "Message not found in this chat", http.StatusNotFound)
+			return
+		}
+
+		// Up to half the page before the target; the rest (including the
+		// target itself) after it
+		olderLimit := limit / 2
+		var older, newer []models.Message
+		if err := base().Where("id < ?", target.ID).Order("id DESC").Limit(olderLimit + 1).Find(&older).Error; err != nil {
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+		hasOlder = len(older) > olderLimit
+		if hasOlder {
+			older = older[:olderLimit]
+		}
+
+		newerLimit := limit - len(older)
+		if err := base().Where("id >= ?", target.ID).Order("id ASC").Limit(newerLimit + 1).Find(&newer).Error; err != nil {
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+		hasNewer = len(newer) > newerLimit
+		if hasNewer {
+			newer = newer[:newerLimit]
+		}
+		messages = append(reverseMessages(older), newer...)
+
+	default:
+		// Newest page, or the page before an anchor
+		q := base()
+		if anchor.Before != 0 {
+			q = q.Where("id < ?", anchor.Before)
+		}
+		if err := q.Order("id DESC").Limit(limit + 1).Find(&messages).Error; err != nil {
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+		hasOlder = len(messages) > limit
+		if hasOlder {
+			messages = messages[:limit]
+		}
+		messages = reverseMessages(messages)
 	}
 
+	// Before/after pages only scan one way; check the other side directly
+	if len(messages) > 0 {
+		if anchor.After != 0 {
+			hasOlder = messageExists(chatID, userID, "id < ?", messages[0].ID)
+		}
+		if anchor.Before != 0 {
+			hasNewer = messageExists(chatID, userID, "id > ?", messages[len(messages)-1].ID)
+		}
+	}
+
+	decorateMessages(messages)
+
 	var total int64
-	database.DB.Model(&models.Message{}).Where("chat_id = ?", chatID).Count(&total)
+	database.DB.Model(&models.Message{}).Scopes(visibleTo(userID)).Where("chat_id = ?", chatID).Count(&total)
 
 	resp := map[string]interface{}{
-		"page":           page,
 		"limit":          limit,
 		"total_messages": total,
 		"messages":       messages,
+		"has_older":      hasOlder,
+		"has_newer":      hasNewer,
+	}
+	if hasOlder {
+		resp["prev_cursor"] = encodeCursor(messageCursor{Before: messages[0].ID})
+	}
+	if hasNewer {
+		resp["next_cursor"] = encodeCursor(messageCursor{After: messages[len(messages)-1].ID})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func SendMultipleMessages(w http.ResponseWriter, r *http.Request) {
-	chatIDStr := mux.Vars(r)["chat_id"]
-	chatID, err := strconv.Atoi(chatIDStr)
-	if err != nil || chatID <= 0 {
-		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
-		return
+// messageCursor is the anchor carried by an opaque message page cursor
+type messageCursor struct {
+	Before uint `json:"b,omitempty"`
+	After  uint `json:"a,omitempty"`
+	Around uint `json:"c,omitempty"`
+}
+
+func (c messageCursor) count() int {
+	n := 0
+	for _, id := range []uint{c.Before, c.After, c.Around} {
+		if id != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func messageExists(chatID, userID uint, cond string, id uint) bool {
+	var count int64
+	database.DB.Model(&models.Message{}).Scopes(visibleTo(userID)).Where("chat_id = ?", chatID).Where(cond, id).Limit(1).Count(&count)
+	return count > 0
+}
+
+// decorateMessages fills in the derived fields of messages about to be
+// returned: rendered system text, quoted reply parents and placeholders for
+// deleted messages
+func decorateMessages(messages []models.Message) {
+	renderSystemMessages(messages)
+	attachReplyPreviews(messages)
+	countPollVotes(messages)
+	for i := range messages {
+		messages[i].Text = displayText(messages[i])
+	}
+}
+
+func reverseMessages(messages []models.Message) []models.Message {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
 	}
+	return messages
+}
 
-	userIDAny := r.Context().Value(userIDKey)
-	userID, ok := userIDAny.(uint)
+func SendMultipleMessages(w http.ResponseWriter, r *http.Request) {
+	// Ensure sender is a member
+	chatID, userID, member, ok := authorizeChatParam(w, r, "chat_id")
 	if !ok {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if !requirePermission(w, member, permSendMessages) {
 		return
 	}
 
@@ -390,6 +659,16 @@ func SendMultipleMessages(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
+	for _, im := range inputMsgs {
+		if reservedMessageType(im.Type) {
+			http.Error(w, `{"error":"Message type `+im.Type+` is reserved"}`, http.StatusBadRequest)
+			return
+		}
+		if im.Type == models.MessageTypeVoice || im.Type == models.MessageTypePoll {
+			http.Error(w, `{"error":"Voice and poll messages must be sent one at a time"}`, http.StatusBadRequest)
+			return
+		}
+	}
 
 	var chat models.Chat
 	if err := database.DB.Preload("Members").First(&chat, chatID).Error; err != nil {
@@ -397,24 +676,11 @@ func SendMultipleMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure sender is a member
-	isMember := false
-	for _, member := range chat.Members {
-		if member.UserID == userID {
-			isMember = true
-			break
-		}
-	}
-	if !isMember {
-		http.Error(w, "Sender is not a member of this chat", http.StatusForbidden)
-		return
-	}
-
 	var messages []models.Message
 	now := time.Now()
 	for _, im := range inputMsgs {
 		messages = append(messages, models.Message{
-			ChatID:    uint(chatID),
+			ChatID:    chatID,
 			SenderID:  userID,
 			Text:      im.Text,
 			Type:      im.Type,
@@ -422,7 +688,7 @@ func SendMultipleMessages(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	err = database.DB.Transaction(func(tx *gorm.DB) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
 		// Save all messages
 		if err := tx.Create(&messages).Error; err != nil {
 			return err
@@ -480,11 +746,17 @@ func SendMultipleMessages(w http.ResponseWriter, r *http.Request) {
 		Preload("StatusTrack").
 		Preload("Reactions").
 		Where("id IN ?", messageIDs).
+		Order("id ASC").
 		Find(&fullMessages).Error; err != nil {
 		http.Error(w, "Failed to load messages", http.StatusInternalServerError)
 		return
 	}
 
+	clearTyping(chatID, userID)
+	for _, m := range fullMessages {
+		publishChatEvent(m.ChatID, realtime.MessageCreated, m)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(fullMessages)
@@ -492,10 +764,8 @@ func SendMultipleMessages(w http.ResponseWriter, r *http.Request) {
 func SearchMessagesInChat(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	chatIDStr := mux.Vars(r)["chat_id"]
-	chatID, err := strconv.Atoi(chatIDStr)
-	if err != nil || chatID <= 0 {
-		http.Error(w, `{"error":"Invalid chat ID"}`, http.StatusBadRequest)
+	chatID, userID, _, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
 		return
 	}
 
@@ -518,6 +788,7 @@ func SearchMessagesInChat(w http.ResponseWriter, r *http.Request) {
 	var texts []string
 	if err := database.DB.
 		Model(&models.Message{}).
+		Scopes(visibleTo(userID)).
 		Where("chat_id = ? AND LOWER(text) LIKE ?", chatID, "%"+strings.ToLower(input.Text)+"%").
 		Order("created_at DESC").
 		Pluck("text", &texts).Error; err != nil {
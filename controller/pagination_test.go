@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// messagePage is the part of a GetMessagesInChat response the tests check
+type messagePage struct {
+	Messages   []models.Message `json:"messages"`
+	HasOlder   bool             `json:"has_older"`
+	HasNewer   bool             `json:"has_newer"`
+	PrevCursor string           `json:"prev_cursor"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+func (p messagePage) texts() []string {
+	texts := make([]string, 0, len(p.Messages))
+	for _, m := range p.Messages {
+		texts = append(texts, m.Text)
+	}
+	return texts
+}
+
+func TestGetMessagesInChatCursors(t *testing.T) {
+	db := setupTestDB(t, "alice", "bob")
+	chat := createChat(t, db, 1, 2)
+	other := createChat(t, db, 1, 2)
+	now := time.Now()
+	var ids []uint
+	for i := 1; i <= 7; i++ {
+		ids = append(ids, sendTestMessage(t, db, chat.ID, 1, fmt.Sprint(i), now).ID)
+	}
+	elsewhere := sendTestMessage(t, db, other.ID, 1, "elsewhere", now)
+	vars := map[string]string{"chat_id": fmt.Sprint(chat.ID)}
+
+	page := func(query url.Values, want int) messagePage {
+		t.Helper()
+		query.Set("limit", "3")
+		rec := call(withQuery(GetMessagesInChat, query.Encode()), "GET", 1, vars, "")
+		var p messagePage
+		if want != http.StatusOK {
+			decodeBody(t, rec, want, nil)
+			return p
+		}
+		decodeBody(t, rec, want, &p)
+		return p
+	}
+
+	tests := []struct {
+		name           string
+		query          url.Values
+		texts          string
+		older, newer   bool
+		prevOK, nextOK bool
+	}{
+		{"newest", url.Values{}, "[5 6 7]", true, false, true, false},
+		{"before", url.Values{"before": {fmt.Sprint(ids[4])}}, "[2 3 4]", true, true, true, true},
+		{"before reaches the start", url.Values{"before": {fmt.Sprint(ids[2])}}, "[1 2]", false, true, false, true},
+		{"after", url.Values{"after": {fmt.Sprint(ids[0])}}, "[2 3 4]", true, true, true, true},
+		{"after the newest", url.Values{"after": {fmt.Sprint(ids[6])}}, "[]", false, false, false, false},
+		{"around", url.Values{"around": {fmt.Sprint(ids[3])}}, "[3 4 5]", true, true, true, true},
+		{"around the first", url.Values{"around": {fmt.Sprint(ids[0])}}, "[1 2 3]", false, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := page(tt.query, http.StatusOK)
+			if got := fmt.Sprint(p.texts()); got != tt.texts {
+				t.Fatalf("got %s, want %s", got, tt.texts)
+			}
+			if p.HasOlder != tt.older || p.HasNewer != tt.newer {
+				t.Fatalf("has_older %v has_newer %v, want %v %v", p.HasOlder, p.HasNewer, tt.older, tt.newer)
+			}
+			if (p.PrevCursor != "") != tt.prevOK || (p.NextCursor != "") != tt.nextOK {
+				t.Fatalf("cursors prev %q next %q", p.PrevCursor, p.NextCursor)
+			}
+		})
+	}
+
+	// Following cursors walks the whole chat without gaps or repeats
+	var seen []string
+	p := page(url.Values{}, http.StatusOK)
+	for {
+		seen = append(p.texts(), seen...)
+		if p.PrevCursor == "" {
+			break
+		}
+		p = page(url.Values{"cursor": {p.PrevCursor}}, http.StatusOK)
+	}
+	if fmt.Sprint(seen) != "[1 2 3 4 5 6 7]" {
+		t.Fatalf("walking back with cursors gave %v", seen)
+	}
+
+	page(url.Values{"around": {fmt.Sprint(elsewhere.ID)}}, http.StatusNotFound)
+	page(url.Values{"before": {fmt.Sprint(ids[3])}, "after": {fmt.Sprint(ids[1])}}, http.StatusBadRequest)
+	page(url.Values{"before": {"0"}}, http.StatusBadRequest)
+	page(url.Values{"cursor": {"not a cursor"}}, http.StatusBadRequest)
+}
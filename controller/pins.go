@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// maxPinnedMessages caps the pins in one chat
+const maxPinnedMessages = 50
+
+// pinPreviewLength limits the quoted text in "pinned" system messages
+const pinPreviewLength = 50
+
+var errTooManyPins = errors.New("pin limit reached")
+
+// pinnedEntry is one pin in the GetPinnedMessages response
+type pinnedEntry struct {
+	models.PinnedMessage
+	PinnedByName string         `json:"pinned_by_name"`
+	Message      models.Message `json:"message"`
+}
+
+// PinMessage pins a message to the top of its chat. Pinning an already
+// pinned message returns the existing pin.
+func PinMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, msg, member, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if !requirePermission(w, member, permPinMessages) {
+		return
+	}
+	if msg.Type == models.MessageTypeSystem {
+		http.Error(w, `{"error":"System messages cannot be pinned"}`, http.StatusBadRequest)
+		return
+	}
+	if msg.DeletedForEveryoneAt != nil {
+		http.Error(w, `{"error":"Deleted messages cannot be pinned"}`, http.StatusBadRequest)
+		return
+	}
+
+	var pin models.PinnedMessage
+	created := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the chat so concurrent pins are counted one at a time and
+		// cannot push it past the limit
+		if err := lockChat(tx, msg.ChatID); err != nil {
+			return err
+		}
+
+		err := tx.Where("message_id = ?", msg.ID).First(&pin).Error
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.PinnedMessage{}).Where("chat_id = ?", msg.ChatID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxPinnedMessages {
+			return errTooManyPins
+		}
+		pin = models.PinnedMessage{ChatID: msg.ChatID, MessageID: msg.ID, PinnedBy: userID}
+		created = true
+		return tx.Create(&pin).Error
+	})
+	if errors.Is(err, errTooManyPins) {
+		http.Error(w, fmt.Sprintf(`{"error":"A chat can have at most %d pinned messages; unpin one first"}`, maxPinnedMessages), http.StatusConflict)
+		return
+	} else if err != nil {
+		// Another request may have pinned the message first; the unique
+		// message ID makes that insert fail, so return the winner's pin
+		pin = models.PinnedMessage{}
+		if database.DB.Where("message_id = ?", msg.ID).First(&pin).Error != nil {
+			http.Error(w, `{"error":"Failed to pin message"}`, http.StatusInternalServerError)
+			return
+		}
+		created = false
+	}
+
+	if created {
+		postSystemMessage(msg.ChatID, models.SystemEvent{
+			Action:    models.SystemMessagePinned,
+			ActorID:   userID,
+			NewValue:  truncateText(displayText(*msg), pinPreviewLength),
+			MessageID: msg.ID,
+		})
+		publishChatEvent(msg.ChatID, realtime.MessagePinned, pin)
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(pin)
+}
+
+// UnpinMessage removes a message's pin
+func UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, msg, member, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if !requirePermission(w, member, permPinMessages) {
+		return
+	}
+
+	result := database.DB.Where("message_id = ?", msg.ID).Delete(&models.PinnedMessage{})
+	if result.Error != nil {
+		http.Error(w, `{"error":"Failed to unpin message"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, `{"error":"Message is not pinned"}`, http.StatusNotFound)
+		return
+	}
+
+	publishChatEvent(msg.ChatID, realtime.MessageUnpinned, map[string]interface{}{
+		"message_id":  msg.ID,
+		"unpinned_by": userID,
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Message unpinned",
+	})
+}
+
+// GetPinnedMessages lists a chat's pinned messages, most recently pinned
+// first, with who pinned each and when
+func GetPinnedMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, userID, _, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+
+	var pins []models.PinnedMessage
+	if err := database.DB.Where("chat_id = ?", chatID).Order("pinned_at DESC, id DESC").Find(&pins).Error; err != nil {
+		http.Error(w, `{"error":"Failed to load pinned messages"}`, http.StatusInternalServerError)
+		return
+	}
+
+	messageIDs := make([]uint, 0, len(pins))
+	pinnerIDs := make([]uint, 0, len(pins))
+	for _, p := range pins {
+		messageIDs = append(messageIDs, p.MessageID)
+		pinnerIDs = append(pinnerIDs, p.PinnedBy)
+	}
+
+	var messages []models.Message
+	if len(messageIDs) > 0 {
+		if err := database.DB.
+			Preload("Sender").
+			Preload("Reactions").
+			Preload("Attachments.Thumbnails").
+			Scopes(withPoll, visibleTo(userID)).
+			Where("id IN ?", messageIDs).
+			Find(&messages).Error; err != nil {
+			http.Error(w, `{"error":"Failed to load pinned messages"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+	decorateMessages(messages)
+	byID := make(map[uint]models.Message, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+
+	// Messages the caller deleted for themselves stay out of their list
+	names := userNames(pinnerIDs)
+	entries := make([]pinnedEntry, 0, len(pins))
+	for _, p := range pins {
+		if m, found := byID[p.MessageID]; found {
+			entries = append(entries, pinnedEntry{PinnedMessage: p, PinnedByName: names[p.PinnedBy], Message: m})
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chat_id": chatID,
+		"pins":    entries,
+		"limit":   maxPinnedMessages,
+	})
+}
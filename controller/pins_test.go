@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPinCap(t *testing.T) {
+	db := setupTestDB(t, "owner", "member")
+	chat := createChat(t, db, 1, 2)
+	setRole(t, chat.ID, 1, models.RoleOwner)
+	pin := func(msg models.Message) int {
+		return call(PinMessage, "POST", 1, map[string]string{"id": fmt.Sprint(msg.ID)}, "").Code
+	}
+
+	var msgs []models.Message
+	for i := 0; i <= maxPinnedMessages; i++ {
+		msgs = append(msgs, sendTestMessage(t, db, chat.ID, 2, fmt.Sprint("m", i), time.Now()))
+	}
+	for _, msg := range msgs[:maxPinnedMessages] {
+		if code := pin(msg); code != http.StatusCreated {
+			t.Fatalf("pin %d: got %d", msg.ID, code)
+		}
+	}
+
+	// Pinning again returns the existing pin, even at the cap
+	if code := pin(msgs[0]); code != http.StatusOK {
+		t.Fatalf("repeat pin: got %d, want 200", code)
+	}
+	extra := msgs[maxPinnedMessages]
+	if code := pin(extra); code != http.StatusConflict {
+		t.Fatalf("pin past the cap: got %d, want 409", code)
+	}
+
+	// Unpinning one makes room
+	rec := call(UnpinMessage, "DELETE", 1, map[string]string{"id": fmt.Sprint(msgs[0].ID)}, "")
+	decodeBody(t, rec, http.StatusOK, nil)
+	if code := pin(extra); code != http.StatusCreated {
+		t.Fatalf("pin after unpinning: got %d, want 201", code)
+	}
+
+	var count int64
+	db.Model(&models.PinnedMessage{}).Where("chat_id = ?", chat.ID).Count(&count)
+	if count != maxPinnedMessages {
+		t.Fatalf("%d pins stored, want %d", count, maxPinnedMessages)
+	}
+}
+
+func TestPinRequiresPermission(t *testing.T) {
+	chat, msg := setupRoleChat(t, models.RoleMember)
+	vars := map[string]string{"id": fmt.Sprint(msg.ID)}
+	if rec := call(PinMessage, "POST", 2, vars, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("member pinned: got %d", rec.Code)
+	}
+	setRole(t, chat.ID, 2, models.RoleModerator)
+	if rec := call(PinMessage, "POST", 2, vars, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("moderator pin: got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limits on the options of a poll
+const (
+	minPollOptions = 2
+	maxPollOptions = 12
+)
+
+// pollInput is the "poll" object of a SendMessage request with type "poll"
+type pollInput struct {
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multiple_choice"`
+	Anonymous      bool       `json:"anonymous"`
+	ClosesAt       *time.Time `json:"closes_at"`
+}
+
+// newPoll validates a poll request and builds the poll to store with its
+// message
+func newPoll(input *pollInput) (*models.Poll, error) {
+	if input == nil {
+		return nil, errors.New("Poll messages need a poll object")
+	}
+	question := strings.TrimSpace(input.Question)
+	if question == "" {
+		return nil, errors.New("Poll question is required")
+	}
+	if len(input.Options) < minPollOptions || len(input.Options) > maxPollOptions {
+		return nil, errors.New("Polls need between 2 and 12 options")
+	}
+	if input.ClosesAt != nil && !input.ClosesAt.After(time.Now()) {
+		return nil, errors.New("closes_at must be in the future")
+	}
+
+	poll := &models.Poll{
+		Question:       question,
+		MultipleChoice: input.MultipleChoice,
+		Anonymous:      input.Anonymous,
+		ClosesAt:       input.ClosesAt,
+	}
+	seen := make(map[string]bool, len(input.Options))
+	for i, text := range input.Options {
+		text = strings.TrimSpace(text)
+		if text == "" || seen[strings.ToLower(text)] {
+			return nil, errors.New("Poll options must be non-empty and distinct")
+		}
+		seen[strings.ToLower(text)] = true
+		poll.Options = append(poll.Options, models.PollOption{Position: i, Text: text})
+	}
+	return poll, nil
+}
+
+// withPoll is a query scope preloading a poll message's question and
+// options in order
+func withPoll(db *gorm.DB) *gorm.DB {
+	return db.Preload("Poll.Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC")
+	})
+}
+
+// countPollVotes fills in the vote counts of the polls among messages
+func countPollVotes(messages []models.Message) {
+	polls := make(map[uint]*models.Poll)
+	for i := range messages {
+		if p := messages[i].Poll; p != nil {
+			polls[p.ID] = p
+		}
+	}
+	if len(polls) == 0 {
+		return
+	}
+	pollIDs := make([]uint, 0, len(polls))
+	for id := range polls {
+		pollIDs = append(pollIDs, id)
+	}
+
+	var optionCounts []struct {
+		OptionID uint
+		Count    int
+	}
+	database.DB.Model(&models.PollVote{}).
+		Select("option_id, COUNT(*) AS count").
+		Where("poll_id IN ?", pollIDs).
+		Group("option_id").
+		Scan(&optionCounts)
+	counts := make(map[uint]int, len(optionCounts))
+	for _, c := range optionCounts {
+		counts[c.OptionID] = c.Count
+	}
+
+	var voterCounts []struct {
+		PollID uint
+		Count  int
+	}
+	database.DB.Model(&models.PollVote{}).
+		Select("poll_id, COUNT(DISTINCT user_id) AS count").
+		Where("poll_id IN ?", pollIDs).
+		Group("poll_id").
+		Scan(&voterCounts)
+	for _, c := range voterCounts {
+		polls[c.PollID].TotalVoters = c.Count
+	}
+
+	for _, p := range polls {
+		for i := range p.Options {
+			p.Options[i].VoteCount = counts[p.Options[i].ID]
+		}
+	}
+}
+
+// removePoll deletes a message's poll, options and votes
+func removePoll(tx *gorm.DB, messageID uint) error {
+	pollIDs := tx.Model(&models.Poll{}).Select("id").Where("message_id = ?", messageID)
+	if err := tx.Where("poll_id IN (?)", pollIDs).Delete(&models.PollVote{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("poll_id IN (?)", pollIDs).Delete(&models.PollOption{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("message_id = ?", messageID).Delete(&models.Poll{}).Error
+}
+
+// removeChatPolls deletes the polls, options and votes of every message in
+// chatID
+func removeChatPolls(tx *gorm.DB, chatID uint) error {
+	messageIDs := tx.Unscoped().Model(&models.Message{}).Select("id").Where("chat_id = ?", chatID)
+	pollIDs := tx.Model(&models.Poll{}).Select("id").Where("message_id IN (?)", messageIDs)
+	if err := tx.Where("poll_id IN (?)", pollIDs).Delete(&models.PollVote{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("poll_id IN (?)", pollIDs).Delete(&models.PollOption{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("message_id IN (?)", messageIDs).Delete(&models.Poll{}).Error
+}
+
+// pollVoter is one member who chose an option
+type pollVoter struct {
+	UserID  uint      `json:"user_id"`
+	Name    string    `json:"name"`
+	VotedAt time.Time `json:"voted_at"`
+}
+
+// pollResults is the response of the results endpoint
+type pollResults struct {
+	Poll    *models.Poll         `json:"poll"`
+	Closed  bool                 `json:"closed"`
+	MyVotes []uint               `json:"my_votes"`
+	Voters  map[uint][]pollVoter `json:"voters,omitempty"` // by option ID; omitted for anonymous polls
+}
+
+// authorizePoll loads the poll message named by the "id" path variable for
+// a chat member
+func authorizePoll(w http.ResponseWriter, r *http.Request) (uint, *models.Message, bool) {
+	userID, msg, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return 0, nil, false
+	}
+	if msg.Type != models.MessageTypePoll {
+		http.Error(w, `{"error":"This message is not a poll"}`, http.StatusBadRequest)
+		return 0, nil, false
+	}
+	if msg.DeletedForEveryoneAt != nil {
+		http.Error(w, `{"error":"This poll was deleted"}`, http.StatusNotFound)
+		return 0, nil, false
+	}
+	if err := database.DB.Scopes(withPoll).First(msg, msg.ID).Error; err != nil || msg.Poll == nil {
+		http.Error(w, `{"error":"Poll not found"}`, http.StatusNotFound)
+		return 0, nil, false
+	}
+	return userID, msg, true
+}
+
+// loadPollResults counts a poll's votes and lists its voters for userID
+func loadPollResults(msg *models.Message, userID uint) (pollResults, error) {
+	countPollVotes([]models.Message{*msg})
+	poll := msg.Poll
+	results := pollResults{Poll: poll, Closed: poll.Closed(time.Now()), MyVotes: []uint{}}
+
+	var votes []models.PollVote
+	if err := database.DB.Where("poll_id = ?", poll.ID).Order("id ASC").Find(&votes).Error; err != nil {
+		return results, err
+	}
+	for _, v := range votes {
+		if v.UserID == userID {
+			results.MyVotes = append(results.MyVotes, v.OptionID)
+		}
+	}
+	if poll.Anonymous {
+		return results, nil
+	}
+
+	userIDs := make([]uint, 0, len(votes))
+	for _, v := range votes {
+		userIDs = append(userIDs, v.UserID)
+	}
+	names := userNames(userIDs)
+	results.Voters = make(map[uint][]pollVoter, len(poll.Options))
+	for _, o := range poll.Options {
+		results.Voters[o.ID] = []pollVoter{}
+	}
+	for _, v := range votes {
+		results.Voters[v.OptionID] = append(results.Voters[v.OptionID], pollVoter{UserID: v.UserID, Name: names[v.UserID], VotedAt: v.CreatedAt})
+	}
+	return results, nil
+}
+
+// GetPollResults returns a poll's vote counts, the caller's choices and,
+// unless the poll is anonymous, who voted for each option
+func GetPollResults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, msg, ok := authorizePoll(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := loadPollResults(msg, userID)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to load poll results"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// CastVote replaces the caller's choices in a poll with option_ids. An empty
+// list withdraws the caller's vote.
+func CastVote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, msg, ok := authorizePoll(w, r)
+	if !ok {
+		return
+	}
+	poll := msg.Poll
+
+	var input struct {
+		OptionIDs []uint `json:"option_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"error":"Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if poll.Closed(time.Now()) {
+		http.Error(w, `{"error":"This poll is closed"}`, http.StatusConflict)
+		return
+	}
+	if !poll.MultipleChoice && len(input.OptionIDs) > 1 {
+		http.Error(w, `{"error":"This poll allows only one choice"}`, http.StatusBadRequest)
+		return
+	}
+
+	valid := make(map[uint]bool, len(poll.Options))
+	for _, o := range poll.Options {
+		valid[o.ID] = true
+	}
+	chosen := make(map[uint]bool, len(input.OptionIDs))
+	var votes []models.PollVote
+	for _, id := range input.OptionIDs {
+		if !valid[id] {
+			http.Error(w, `{"error":"option_ids must be options of this poll"}`, http.StatusBadRequest)
+			return
+		}
+		if !chosen[id] {
+			chosen[id] = true
+			votes = append(votes, models.PollVote{PollID: poll.ID, OptionID: id, UserID: userID})
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the poll so concurrent votes are applied one at a time and a
+		// single-choice poll never ends up with two of the caller's votes
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Poll{}, poll.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("poll_id = ? AND user_id = ?", poll.ID, userID).Delete(&models.PollVote{}).Error; err != nil {
+			return err
+		}
+		if len(votes) == 0 {
+			return nil
+		}
+		return tx.Create(&votes).Error
+	})
+	if err != nil {
+		http.Error(w, `{"error":"Failed to record vote"}`, http.StatusInternalServerError)
+		return
+	}
+
+	results, err := loadPollResults(msg, userID)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to load poll results"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Everyone sees the new counts; voters are only named in open polls
+	update := map[string]interface{}{
+		"message_id": msg.ID,
+		"poll":       results.Poll,
+	}
+	if !poll.Anonymous {
+		update["user_id"] = userID
+		update["option_ids"] = results.MyVotes
+	}
+	publishChatEvent(msg.ChatID, realtime.PollUpdated, update)
+
+	json.NewEncoder(w).Encode(results)
+}
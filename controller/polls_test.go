@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// createPoll stores a poll message from user 1 with options "a", "b" and "c"
+func createPoll(t *testing.T, db *gorm.DB, chatID uint, multiple bool, closesAt *time.Time) models.Message {
+	t.Helper()
+	msg := models.Message{ChatID: chatID, SenderID: 1, Text: "lunch?", Type: models.MessageTypePoll, CreatedAt: time.Now(),
+		Poll: &models.Poll{Question: "lunch?", MultipleChoice: multiple, ClosesAt: closesAt, Options: []models.PollOption{
+			{Position: 0, Text: "a"}, {Position: 1, Text: "b"}, {Position: 2, Text: "c"},
+		}}}
+	if err := db.Create(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestCastVote(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name     string
+		multiple bool
+		closesAt *time.Time
+		votes    [][]int // options by position, cast in turn by user 2
+		status   int     // of the last vote
+		counts   [3]int  // afterwards
+	}{
+		{"single choice", false, nil, [][]int{{0}}, http.StatusOK, [3]int{1, 0, 0}},
+		{"single choice rejects two", false, nil, [][]int{{0}, {0, 1}}, http.StatusBadRequest, [3]int{1, 0, 0}},
+		{"changing a vote replaces it", false, nil, [][]int{{0}, {2}}, http.StatusOK, [3]int{0, 0, 1}},
+		{"empty list withdraws", false, nil, [][]int{{1}, {}}, http.StatusOK, [3]int{0, 0, 0}},
+		{"multiple choice", true, nil, [][]int{{0, 2, 2}}, http.StatusOK, [3]int{1, 0, 1}},
+		{"closed poll", false, &past, [][]int{{0}}, http.StatusConflict, [3]int{0, 0, 0}},
+		{"option of another poll", false, nil, [][]int{{-1}}, http.StatusBadRequest, [3]int{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, "asker", "voter")
+			chat := createChat(t, db, 1, 2)
+			poll := createPoll(t, db, chat.ID, tt.multiple, tt.closesAt)
+			other := createPoll(t, db, chat.ID, false, nil)
+			vars := map[string]string{"id": fmt.Sprint(poll.ID)}
+
+			var rec *httptest.ResponseRecorder
+			for _, positions := range tt.votes {
+				ids := []uint{}
+				for _, p := range positions {
+					if p < 0 {
+						ids = append(ids, other.Poll.Options[0].ID)
+					} else {
+						ids = append(ids, poll.Poll.Options[p].ID)
+					}
+				}
+				body, _ := json.Marshal(map[string][]uint{"option_ids": ids})
+				rec = call(CastVote, "POST", 2, vars, string(body))
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			var results pollResults
+			decodeBody(t, call(GetPollResults, "GET", 1, vars, ""), http.StatusOK, &results)
+			for i, o := range results.Poll.Options {
+				if o.VoteCount != tt.counts[i] {
+					t.Fatalf("counts %+v, want %v", results.Poll.Options, tt.counts)
+				}
+			}
+		})
+	}
+}
+
+func TestSendPollMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		poll   string
+		status int
+	}{
+		{"valid", `{"question":"lunch?","options":["pizza","sushi"]}`, http.StatusCreated},
+		{"one option", `{"question":"lunch?","options":["pizza"]}`, http.StatusBadRequest},
+		{"duplicate options", `{"question":"lunch?","options":["pizza"," Pizza "]}`, http.StatusBadRequest},
+		{"no question", `{"question":" ","options":["pizza","sushi"]}`, http.StatusBadRequest},
+		{"already closed", `{"question":"lunch?","options":["pizza","sushi"],"closes_at":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"missing poll", `null`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, "asker", "voter")
+			chat := createChat(t, db, 1, 2)
+			body := fmt.Sprintf(`{"chat_id":%d,"type":"poll","poll":%s}`, chat.ID, tt.poll)
+			rec := call(SendMessage, "POST", 1, nil, body)
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var polls int64
+			db.Model(&models.Poll{}).Count(&polls)
+			if (polls == 1) != (tt.status == http.StatusCreated) {
+				t.Fatalf("%d polls stored", polls)
+			}
+		})
+	}
+}
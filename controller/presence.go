@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// A user counts as online for this long after being seen by any instance
+	onlineWindow = 60 * time.Second
+
+	// How often buffered activity is written and users holding a live
+	// connection get last_seen_at refreshed
+	presenceHeartbeat = 30 * time.Second
+)
+
+// pendingSeen buffers request-driven activity until the next heartbeat so
+// requests never wait on a presence write
+var pendingSeen sync.Map // map[uint]time.Time
+
+// presenceInfo is what the presence endpoints return for one user
+type presenceInfo struct {
+	UserID     uint       `json:"user_id"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	Hidden     bool       `json:"hidden,omitempty"` // privacy setting withheld the details
+}
+
+// StartPresence wires connection tracking into last_seen_at and keeps it
+// fresh for active users. Call once after InitDB.
+func StartPresence() {
+	// A closed connection is just more activity: online_until runs out on its
+	// own, so a connection the user still holds on another instance keeps
+	// them online and closing never waits on the database
+	realtime.Default.OnConnect = markSeen
+	realtime.Default.OnDisconnect = markSeen
+
+	go func() {
+		ticker := time.NewTicker(presenceHeartbeat)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			flushSeen()
+			if userIDs := realtime.Default.ConnectedUsers(); len(userIDs) > 0 {
+				database.DB.Model(&models.User{}).
+					Where("id IN ?", userIDs).
+					Updates(map[string]interface{}{
+						"last_seen_at": now,
+						"online_until": now.Add(onlineWindow),
+					})
+			}
+		}
+	}()
+}
+
+// PresenceMiddleware records activity for authenticated requests. It must
+// run after AuthMiddleware or StreamAuthMiddleware.
+func PresenceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, ok := r.Context().Value(userIDKey).(uint); ok {
+			markSeen(userID)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// flushSeen writes the buffered activity of every user in one UPDATE
+func flushSeen() {
+	var userIDs []uint
+	var seenArgs, untilArgs []interface{}
+	pendingSeen.Range(func(key, value interface{}) bool {
+		userID, at := key.(uint), value.(time.Time)
+		// Keep activity recorded since this flush started for the next one
+		if pendingSeen.CompareAndDelete(key, value) {
+			userIDs = append(userIDs, userID)
+			seenArgs = append(seenArgs, userID, at)
+			untilArgs = append(untilArgs, userID, at.Add(onlineWindow))
+		}
+		return true
+	})
+	if len(userIDs) == 0 {
+		return
+	}
+
+	cases := "CASE id " + strings.Repeat("WHEN ? THEN ? ", len(userIDs)) + "END"
+	database.DB.Model(&models.User{}).
+		Where("id IN ?", userIDs).
+		Updates(map[string]interface{}{
+			"last_seen_at": gorm.Expr(cases, seenArgs...),
+			"online_until": gorm.Expr(cases, untilArgs...),
+		})
+}
+
+// markSeen buffers activity by userID for the next flush
+func markSeen(userID uint) {
+	pendingSeen.Store(userID, time.Now())
+}
+
+// GetUserPresence returns whether a user is online and when they were last
+// seen, subject to that user's privacy setting
+func GetUserPresence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	viewerID, ok := r.Context().Value(userIDKey).(uint)
+	if !ok {
+		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || targetID <= 0 {
+		http.Error(w, `{"error":"Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Select("id", "last_seen_at", "online_until", "last_seen_visibility").
+		First(&user, targetID).Error; err != nil {
+		http.Error(w, `{"error":"User not found"}`, http.StatusNotFound)
+		return
+	}
+
+	contacts, err := contactIDs(viewerID)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to load contacts"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(buildPresence(viewerID, user, contacts))
+}
+
+// GetChatPresence returns presence for every member of a chat in one call
+func GetChatPresence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, viewerID, _, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+
+	var users []models.User
+	if err := database.DB.Select("users.id", "users.last_seen_at", "users.online_until", "users.last_seen_visibility").
+		Joins("JOIN chat_members ON chat_members.user_id = users.id").
+		Where("chat_members.chat_id = ?", chatID).
+		Find(&users).Error; err != nil {
+		http.Error(w, `{"error":"Failed to fetch members"}`, http.StatusInternalServerError)
+		return
+	}
+
+	contacts, err := contactIDs(viewerID)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to load contacts"}`, http.StatusInternalServerError)
+		return
+	}
+
+	presence := make([]presenceInfo, 0, len(users))
+	for _, user := range users {
+		presence = append(presence, buildPresence(viewerID, user, contacts))
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chat_id":  chatID,
+		"presence": presence,
+	})
+}
+
+// UpdatePrivacy changes who may see the caller's last-seen time
+func UpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value(userIDKey).(uint)
+	if !ok {
+		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		LastSeenVisibility string `json:"last_seen_visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, `{"error":"Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch input.LastSeenVisibility {
+	case models.VisibilityEveryone, models.VisibilityContacts, models.VisibilityNobody:
+	default:
+		http.Error(w, `{"error":"last_seen_visibility must be 'everyone', 'contacts' or 'nobody'"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("last_seen_visibility", input.LastSeenVisibility).Error; err != nil {
+		http.Error(w, `{"error":"Failed to update privacy settings"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"last_seen_visibility": input.LastSeenVisibility,
+	})
+}
+
+// buildPresence applies the target's privacy setting for viewerID
+func buildPresence(viewerID uint, user models.User, contacts map[uint]bool) presenceInfo {
+	info := presenceInfo{UserID: user.ID}
+
+	visible := viewerID == user.ID
+	switch user.LastSeenVisibility {
+	case models.VisibilityNobody:
+	case models.VisibilityContacts:
+		visible = visible || contacts[user.ID]
+	default:
+		visible = true
+	}
+	if !visible {
+		info.Hidden = true
+		return info
+	}
+
+	info.LastSeenAt = user.LastSeenAt
+	info.Online = realtime.Default.Connected(user.ID) ||
+		(user.OnlineUntil != nil && time.Now().Before(*user.OnlineUntil))
+	return info
+}
+
+// contactIDs returns the users viewerID shares a one-to-one chat with
+func contactIDs(viewerID uint) (map[uint]bool, error) {
+	var userIDs []uint
+	err := database.DB.Model(&models.ChatMember{}).
+		Joins("JOIN chats ON chats.id = chat_members.chat_id AND chats.is_group = ? AND chats.deleted_at IS NULL", false).
+		Where("chat_members.chat_id IN (?)",
+			database.DB.Model(&models.ChatMember{}).Select("chat_id").Where("user_id = ?", viewerID)).
+		Where("chat_members.user_id <> ?", viewerID).
+		Pluck("chat_members.user_id", &userIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make(map[uint]bool, len(userIDs))
+	for _, id := range userIDs {
+		contacts[id] = true
+	}
+	return contacts, nil
+}
@@ -3,32 +3,25 @@ package controller
 import (
 	"ChatApiServer/database"
 	"ChatApiServer/models"
+	"ChatApiServer/realtime"
 	"encoding/json"
 	"errors"
 	"net/http"
-	"strconv"
 
-	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
 // AddOrUpdateReaction handles adding or updating a reaction to a specific message
 func AddOrUpdateReaction(w http.ResponseWriter, r *http.Request) {
-	// Safely extract user ID from context
-	userIDRaw := r.Context().Value(userIDKey)
-	userID, ok := userIDRaw.(uint)
+	userID, msg, _, ok := authorizeMessageParam(w, r, "message_id")
 	if !ok {
-		http.Error(w, `{"error":"Unauthorized or missing user ID"}`, http.StatusUnauthorized)
 		return
 	}
-
-	// Get and validate message ID
-	messageIDStr := mux.Vars(r)["message_id"]
-	messageID, err := strconv.Atoi(messageIDStr)
-	if err != nil || messageID <= 0 {
-		http.Error(w, `{"error":"Invalid message ID"}`, http.StatusBadRequest)
+	if msg.DeletedForEveryoneAt != nil {
+		http.Error(w, `{"error":"Cannot react to a deleted message"}`, http.StatusBadRequest)
 		return
 	}
+	messageID := msg.ID
 
 	// Decode JSON body for emoji
 	var payload struct {
@@ -40,17 +33,18 @@ func AddOrUpdateReaction(w http.ResponseWriter, r *http.Request) {
 	}
 
 	db := database.DB
+
 	var reaction models.Reaction
 
 	// Check if the reaction already exists
-	err = db.Where("message_id = ? AND user_id = ?", messageID, userID).
+	err := db.Where("message_id = ? AND user_id = ?", messageID, userID).
 		First(&reaction).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// Create new reaction
 			reaction = models.Reaction{
-				MessageID: uint(messageID),
+				MessageID: messageID,
 				UserID:    userID,
 				Emoji:     payload.Emoji,
 			}
@@ -58,6 +52,7 @@ func AddOrUpdateReaction(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, `{"error":"Failed to save reaction"}`, http.StatusInternalServerError)
 				return
 			}
+			publishChatEvent(msg.ChatID, realtime.ReactionAdded, reactionEventData(reaction))
 			w.WriteHeader(http.StatusCreated)
 		} else {
 			http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
@@ -70,6 +65,7 @@ func AddOrUpdateReaction(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, `{"error":"Failed to update reaction"}`, http.StatusInternalServerError)
 			return
 		}
+		publishChatEvent(msg.ChatID, realtime.ReactionUpdated, reactionEventData(reaction))
 		w.WriteHeader(http.StatusOK)
 	}
 
@@ -80,42 +76,39 @@ func AddOrUpdateReaction(w http.ResponseWriter, r *http.Request) {
 
 // RemoveReaction deletes the current user's reaction to a specific message
 func RemoveReaction(w http.ResponseWriter, r *http.Request) {
-	// Safely extract user ID from context
-	userIDRaw := r.Context().Value(userIDKey)
-	userID, ok := userIDRaw.(uint)
+	userID, msg, _, ok := authorizeMessageParam(w, r, "message_id")
 	if !ok {
-		http.Error(w, `{"error":"Unauthorized or missing user ID"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Parse and validate message ID
-	messageIDStr := mux.Vars(r)["message_id"]
-	messageID, err := strconv.Atoi(messageIDStr)
-	if err != nil || messageID <= 0 {
-		http.Error(w, `{"error":"Invalid message ID"}`, http.StatusBadRequest)
 		return
 	}
+	messageID := msg.ID
 
 	// Delete reaction for this user and message
-	if err := database.DB.Where("message_id = ? AND user_id = ?", messageID, userID).
-		Delete(&models.Reaction{}).Error; err != nil {
+	result := database.DB.Where("message_id = ? AND user_id = ?", messageID, userID).
+		Delete(&models.Reaction{})
+	if result.Error != nil {
 		http.Error(w, `{"error":"Failed to delete reaction"}`, http.StatusInternalServerError)
 		return
 	}
 
+	if result.RowsAffected > 0 {
+		publishChatEvent(msg.ChatID, realtime.ReactionRemoved, map[string]uint{
+			"message_id": messageID,
+			"user_id":    userID,
+		})
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // GetReactions returns all reactions for a message
 func GetReactions(w http.ResponseWriter, r *http.Request) {
-	messageID, err := strconv.Atoi(mux.Vars(r)["message_id"])
-	if err != nil || messageID <= 0 {
-		http.Error(w, `{"error":"Invalid message ID"}`, http.StatusBadRequest)
+	_, msg, _, ok := authorizeMessageParam(w, r, "message_id")
+	if !ok {
 		return
 	}
 
 	var reactions []models.Reaction
-	if err := database.DB.Where("message_id = ?", messageID).Find(&reactions).Error; err != nil {
+	if err := database.DB.Where("message_id = ?", msg.ID).Find(&reactions).Error; err != nil {
 		http.Error(w, `{"error":"Failed to fetch reactions"}`, http.StatusInternalServerError)
 		return
 	}
@@ -123,3 +116,13 @@ func GetReactions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(reactions)
 }
+
+// reactionEventData includes the reacting user, which Reaction hides from JSON
+func reactionEventData(reaction models.Reaction) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         reaction.ID,
+		"message_id": reaction.MessageID,
+		"user_id":    reaction.UserID,
+		"emoji":      reaction.Emoji,
+	}
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+}
+
+// checkOrigin allows WebSocket upgrades from non-browser clients (no Origin
+// header), from the API's own origin, and from the origins listed in
+// ALLOWED_ORIGINS (comma separated, "*" for any)
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		allowed = strings.TrimSuffix(strings.TrimSpace(allowed), "/")
+		if allowed == "*" || (allowed != "" && strings.EqualFold(allowed, origin)) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	realtime.Default.Authorize = isChatMember
+	realtime.Default.HandleAction = handleClientAction
+	realtime.Typing.OnExpire = func(chatID, userID uint) {
+		publishTyping(chatID, userID, realtime.TypingStop)
+	}
+}
+
+// ServeWS upgrades the request to a WebSocket and streams chat events to the
+// caller. The connection starts subscribed to every chat the user belongs to.
+func ServeWS(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(uint)
+	if !ok {
+		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	chatIDs, err := memberChatIDs(userID)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to fetch chat memberships"}`, http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		log.Printf("websocket upgrade failed for user %d: %v", userID, err)
+		return
+	}
+
+	realtime.Default.Serve(conn, userID, chatIDs)
+}
+
+// StreamEvents is the Server-Sent Events fallback for clients that cannot
+// hold a WebSocket. It carries the same chat events as ServeWS and honours
+// Last-Event-ID so reconnecting clients replay what they missed.
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDKey).(uint)
+	if !ok {
+		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	chatIDs, err := memberChatIDs(userID)
+	if err != nil {
+		http.Error(w, `{"error":"Failed to fetch chat memberships"}`, http.StatusInternalServerError)
+		return
+	}
+
+	realtime.Default.ServeSSE(w, r, userID, chatIDs)
+}
+
+// memberChatIDs lists the chats userID belongs to
+func memberChatIDs(userID uint) ([]uint, error) {
+	var chatIDs []uint
+	err := database.DB.Model(&models.ChatMember{}).
+		Where("user_id = ?", userID).
+		Pluck("chat_id", &chatIDs).Error
+	return chatIDs, err
+}
+
+// publishChatEvent pushes an event to every connected member of chatID
+func publishChatEvent(chatID uint, eventType string, data interface{}) {
+	realtime.Publish(realtime.Event{
+		Type:   eventType,
+		ChatID: chatID,
+		Data:   data,
+	})
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// receiptUser is one recipient in an aggregated receipt list
+type receiptUser struct {
+	UserID uint      `json:"user_id"`
+	Name   string    `json:"name"`
+	At     time.Time `json:"at"`
+}
+
+// receiptGroup counts recipients that reached a state
+type receiptGroup struct {
+	Count int           `json:"count"`
+	Users []receiptUser `json:"users"`
+}
+
+// receiptSummary is the aggregate receipt view attached to GetMessage
+type receiptSummary struct {
+	Recipients int           `json:"recipients"`
+	Delivered  receiptGroup  `json:"delivered"`
+	Read       receiptGroup  `json:"read"`
+	Played     *receiptGroup `json:"played,omitempty"` // voice messages only
+}
+
+// markReceipt advances the caller's own MessageStatus row to state
+// ("delivered", "read" or, for voice messages, "played"). Other recipients'
+// rows are never touched.
+func markReceipt(w http.ResponseWriter, r *http.Request, state string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, msg, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if state == "played" && msg.Type != models.MessageTypeVoice {
+		http.Error(w, `{"error":"Only voice messages can be marked as played"}`, http.StatusBadRequest)
+		return
+	}
+
+	var status models.MessageStatus
+	err := database.DB.Where("message_id = ? AND user_id = ?", msg.ID, userID).First(&status).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Senders have no status row for their own messages
+		http.Error(w, fmt.Sprintf(`{"error":"No %s status found for this message"}`, state), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{}
+	if status.DeliveredAt == nil {
+		// Reading implies delivery
+		updates["delivered_at"] = now
+		status.DeliveredAt = &now
+	}
+	if state != "delivered" && status.ReadAt == nil {
+		// Playing implies reading
+		updates["read_at"] = now
+		status.ReadAt = &now
+	}
+	if state == "played" && status.PlayedAt == nil {
+		updates["played_at"] = now
+		status.PlayedAt = &now
+	}
+	if status.PlayedAt != nil {
+		updates["status"] = "played"
+		status.Status = "played"
+	} else if status.ReadAt != nil {
+		updates["status"] = "read"
+		status.Status = "read"
+	} else {
+		updates["status"] = "delivered"
+		status.Status = "delivered"
+	}
+
+	if err := database.DB.Model(&status).Updates(updates).Error; err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"Failed to update %s status"}`, state), http.StatusInternalServerError)
+		return
+	}
+
+	publishChatEvent(msg.ChatID, realtime.ReceiptUpdated, map[string]interface{}{
+		"message_id":   msg.ID,
+		"user_id":      userID,
+		"status":       status.Status,
+		"delivered_at": status.DeliveredAt,
+		"read_at":      status.ReadAt,
+		"played_at":    status.PlayedAt,
+	})
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Marked as " + state,
+	})
+}
+
+// summarizeReceipts groups a message's status rows into delivered/read
+// (and played, for voice messages) counts with recipient names, ordered by
+// when each state was reached
+func summarizeReceipts(msg models.Message) (receiptSummary, error) {
+	statuses := msg.StatusTrack
+	summary := receiptSummary{
+		Recipients: len(statuses),
+		Delivered:  receiptGroup{Users: []receiptUser{}},
+		Read:       receiptGroup{Users: []receiptUser{}},
+	}
+	if msg.Type == models.MessageTypeVoice {
+		summary.Played = &receiptGroup{Users: []receiptUser{}}
+	}
+	if len(statuses) == 0 {
+		return summary, nil
+	}
+
+	userIDs := make([]uint, 0, len(statuses))
+	for _, s := range statuses {
+		userIDs = append(userIDs, s.UserID)
+	}
+	var users []models.User
+	if err := database.DB.Select("id", "name").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return summary, err
+	}
+	names := make(map[uint]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Name
+	}
+
+	for _, s := range statuses {
+		if s.DeliveredAt != nil {
+			summary.Delivered.Users = append(summary.Delivered.Users, receiptUser{UserID: s.UserID, Name: names[s.UserID], At: *s.DeliveredAt})
+		}
+		if s.ReadAt != nil {
+			summary.Read.Users = append(summary.Read.Users, receiptUser{UserID: s.UserID, Name: names[s.UserID], At: *s.ReadAt})
+		}
+		if s.PlayedAt != nil && summary.Played != nil {
+			summary.Played.Users = append(summary.Played.Users, receiptUser{UserID: s.UserID, Name: names[s.UserID], At: *s.PlayedAt})
+		}
+	}
+	sortReceiptUsers(summary.Delivered.Users)
+	sortReceiptUsers(summary.Read.Users)
+	summary.Delivered.Count = len(summary.Delivered.Users)
+	summary.Read.Count = len(summary.Read.Users)
+	if summary.Played != nil {
+		sortReceiptUsers(summary.Played.Users)
+		summary.Played.Count = len(summary.Played.Users)
+	}
+	return summary, nil
+}
+
+func sortReceiptUsers(users []receiptUser) {
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].At.Before(users[j].At)
+	})
+}
+
+// MarkChatRead advances the caller's read-up-to watermark in a chat and marks
+// every message at or below it as read for the caller in one call. Omitting
+// message_id acknowledges the whole chat up to its latest message.
+func MarkChatRead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, userID, member, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MessageID uint `json:"message_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, `{"error":"Invalid JSON"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Resolve the target message, which must belong to this chat
+	var target models.Message
+	query := database.DB.Select("id", "chat_id").Where("chat_id = ?", chatID)
+	if input.MessageID != 0 {
+		query = query.Where("id = ?", input.MessageID)
+	} else {
+		query = query.Order("id DESC")
+	}
+	if err := query.First(&target).Error; err != nil {
+		http.Error(w, `{"error":"Message not found in this chat"}`, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	var moved bool
+	var marked int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Only ever move the watermark forward
+		result := tx.Model(&models.ChatMember{}).
+			Where("id = ? AND (last_read_message_id IS NULL OR last_read_message_id < ?)", member.ID, target.ID).
+			Updates(map[string]interface{}{
+				"last_read_message_id": target.ID,
+				"last_read_at":         now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		moved = true
+
+		result = tx.Model(&models.MessageStatus{}).
+			Where("user_id = ? AND read_at IS NULL", userID).
+			Where("message_id IN (?)", tx.Model(&models.Message{}).Select("id").
+				Where("chat_id = ? AND id <= ?", chatID, target.ID)).
+			Updates(map[string]interface{}{
+				"status":       "read",
+				"read_at":      now,
+				"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", now),
+			})
+		marked = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		http.Error(w, `{"error":"Failed to update read watermark"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Re-read so a concurrent call that moved further ahead is reported
+	database.DB.First(member, member.ID)
+
+	if moved {
+		publishChatEvent(chatID, realtime.ReadUpTo, map[string]interface{}{
+			"user_id":              userID,
+			"last_read_message_id": member.LastReadMessageID,
+			"last_read_at":         member.LastReadAt,
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chat_id":              chatID,
+		"last_read_message_id": member.LastReadMessageID,
+		"last_read_at":         member.LastReadAt,
+		"marked_read":          marked,
+	})
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMarkReceiptOnlyTouchesCaller(t *testing.T) {
+	db := setupTestDB(t, "sender", "reader", "other")
+	chat := createChat(t, db, 1, 2, 3)
+	msg := sendTestMessage(t, db, chat.ID, 1, "hello", time.Now())
+	vars := map[string]string{"id": fmt.Sprint(msg.ID)}
+
+	decodeBody(t, call(MarkRead, "PUT", 2, vars, ""), http.StatusOK, nil)
+
+	var statuses []models.MessageStatus
+	db.Where("message_id = ?", msg.ID).Order("user_id").Find(&statuses)
+	reader, other := statuses[0], statuses[1]
+	if reader.Status != "read" || reader.ReadAt == nil || reader.DeliveredAt == nil {
+		t.Fatalf("reader's receipt not advanced to read with delivery: %+v", reader)
+	}
+	if other.Status != "sent" || other.DeliveredAt != nil || other.ReadAt != nil {
+		t.Fatalf("other recipient's receipt changed: %+v", other)
+	}
+
+	// Delivery after reading must not move the receipt back
+	decodeBody(t, call(MarkDelivered, "PUT", 2, vars, ""), http.StatusOK, nil)
+	db.First(&reader, reader.ID)
+	if reader.Status != "read" {
+		t.Fatalf("delivered after read changed status to %q", reader.Status)
+	}
+
+	// The sender has no receipt of their own
+	decodeBody(t, call(MarkRead, "PUT", 1, vars, ""), http.StatusNotFound, nil)
+}
+
+func TestMarkChatReadWatermark(t *testing.T) {
+	db := setupTestDB(t, "sender", "reader")
+	chat := createChat(t, db, 1, 2)
+	other := createChat(t, db, 1, 2)
+	now := time.Now()
+	var msgs []models.Message
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, sendTestMessage(t, db, chat.ID, 1, fmt.Sprint("m", i), now))
+	}
+	foreign := sendTestMessage(t, db, other.ID, 1, "elsewhere", now)
+	vars := map[string]string{"chat_id": fmt.Sprint(chat.ID)}
+
+	var got struct {
+		LastReadMessageID uint  `json:"last_read_message_id"`
+		MarkedRead        int64 `json:"marked_read"`
+	}
+	decodeBody(t, call(MarkChatRead, "PUT", 2, vars, fmt.Sprintf(`{"message_id":%d}`, msgs[1].ID)), http.StatusOK, &got)
+	if got.LastReadMessageID != msgs[1].ID || got.MarkedRead != 2 {
+		t.Fatalf("read up to the second message: %+v", got)
+	}
+
+	// An older message leaves the watermark where it is
+	got.MarkedRead = -1
+	decodeBody(t, call(MarkChatRead, "PUT", 2, vars, fmt.Sprintf(`{"message_id":%d}`, msgs[0].ID)), http.StatusOK, &got)
+	if got.LastReadMessageID != msgs[1].ID || got.MarkedRead != 0 {
+		t.Fatalf("watermark moved backwards: %+v", got)
+	}
+
+	// A message from another chat is rejected
+	decodeBody(t, call(MarkChatRead, "PUT", 2, vars, fmt.Sprintf(`{"message_id":%d}`, foreign.ID)), http.StatusNotFound, nil)
+
+	// No body reads up to the newest message
+	decodeBody(t, call(MarkChatRead, "PUT", 2, vars, ""), http.StatusOK, &got)
+	if got.LastReadMessageID != msgs[2].ID || got.MarkedRead != 1 {
+		t.Fatalf("read up to the newest message: %+v", got)
+	}
+	var unread int64
+	db.Model(&models.MessageStatus{}).Where("user_id = 2 AND read_at IS NULL").Count(&unread)
+	if unread != 1 {
+		t.Fatalf("want only the other chat's message unread, got %d", unread)
+	}
+}
+
+func TestMarkPlayed(t *testing.T) {
+	db := setupTestDB(t, "sender", "listener")
+	chat := createChat(t, db, 1, 2)
+	text := sendTestMessage(t, db, chat.ID, 1, "hello", time.Now())
+	voice := sendTestMessage(t, db, chat.ID, 1, "", time.Now())
+	db.Model(&voice).Update("type", models.MessageTypeVoice)
+
+	rec := call(MarkPlayed, "PUT", 2, map[string]string{"id": fmt.Sprint(text.ID)}, "")
+	decodeBody(t, rec, http.StatusBadRequest, nil)
+
+	decodeBody(t, call(MarkPlayed, "PUT", 2, map[string]string{"id": fmt.Sprint(voice.ID)}, ""), http.StatusOK, nil)
+	var status models.MessageStatus
+	db.Where("message_id = ? AND user_id = ?", voice.ID, 2).First(&status)
+	if status.Status != "played" || status.PlayedAt == nil || status.ReadAt == nil || status.DeliveredAt == nil {
+		t.Fatalf("playing did not imply read and delivered: %+v", status)
+	}
+
+	// Reading again keeps the played state
+	decodeBody(t, call(MarkRead, "PUT", 2, map[string]string{"id": fmt.Sprint(voice.ID)}, ""), http.StatusOK, nil)
+	db.First(&status, status.ID)
+	if status.Status != "played" {
+		t.Fatalf("read after played changed status to %q", status.Status)
+	}
+}
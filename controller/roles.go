@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// permission is an action a chat member may be allowed to take
+type permission string
+
+const (
+	permRenameChat     permission = "rename_chat"
+	permChangeSettings permission = "change_settings"
+	permManageMembers  permission = "manage_members"
+	permManageRoles    permission = "manage_roles"
+	permDeleteMessages permission = "delete_messages" // other members' messages
+	permPinMessages    permission = "pin_messages"
+	permDeleteChat     permission = "delete_chat"
+	permSendMessages   permission = "send_messages"
+)
+
+// rolePermissions is the permission matrix for chat roles
+var rolePermissions = map[string]map[permission]bool{
+	models.RoleOwner: {
+		permRenameChat: true, permChangeSettings: true, permManageMembers: true, permManageRoles: true,
+		permDeleteMessages: true, permPinMessages: true, permDeleteChat: true, permSendMessages: true,
+	},
+	models.RoleAdmin: {
+		permRenameChat: true, permChangeSettings: true, permManageMembers: true, permManageRoles: true,
+		permDeleteMessages: true, permPinMessages: true, permSendMessages: true,
+	},
+	models.RoleModerator: {
+		permDeleteMessages: true, permPinMessages: true, permSendMessages: true,
+	},
+	models.RoleMember: {
+		permSendMessages: true,
+	},
+	models.RoleReadOnly: {},
+}
+
+// roleRank orders roles so members can only manage roles below their own
+var roleRank = map[string]int{
+	models.RoleOwner:     4,
+	models.RoleAdmin:     3,
+	models.RoleModerator: 2,
+	models.RoleMember:    1,
+	models.RoleReadOnly:  0,
+}
+
+// validRole reports whether role is one of the defined chat roles
+func validRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// memberRole returns the member's role, treating unknown values from before
+// roles were enforced as plain members
+func memberRole(member *models.ChatMember) string {
+	if member == nil {
+		return ""
+	}
+	if validRole(member.Role) {
+		return member.Role
+	}
+	return models.RoleMember
+}
+
+// can reports whether member's role grants perm
+func can(member *models.ChatMember, perm permission) bool {
+	return rolePermissions[memberRole(member)][perm]
+}
+
+// outranks reports whether member's role is strictly above role
+func outranks(member *models.ChatMember, role string) bool {
+	return member != nil && roleRank[memberRole(member)] > roleRank[role]
+}
+
+// requirePermission writes 403 unless member's role grants perm
+func requirePermission(w http.ResponseWriter, member *models.ChatMember, perm permission) bool {
+	if !can(member, perm) {
+		http.Error(w, `{"error":"Your role in this chat does not allow this action"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// UpdateMemberRole changes another member's role. The caller needs the
+// manage_roles permission, must outrank the member's current role and cannot
+// grant a role above their own; ownership moves only by transfer.
+func UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, userID, member, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+	if !requirePermission(w, member, permManageRoles) {
+		return
+	}
+
+	targetID, ok := pathID(w, r, "user_id", "user")
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || !validRole(input.Role) {
+		http.Error(w, `{"error":"role must be one of admin, moderator, member, read_only"}`, http.StatusBadRequest)
+		return
+	}
+	if input.Role == models.RoleOwner {
+		http.Error(w, `{"error":"Ownership can only be transferred"}`, http.StatusBadRequest)
+		return
+	}
+	if targetID == userID {
+		http.Error(w, `{"error":"You cannot change your own role"}`, http.StatusForbidden)
+		return
+	}
+
+	var chat models.Chat
+	if err := database.DB.Select("id", "is_group").First(&chat, chatID).Error; err != nil {
+		http.Error(w, `{"error":"Chat not found"}`, http.StatusNotFound)
+		return
+	}
+	if !chat.IsGroup {
+		http.Error(w, `{"error":"Roles can only be changed in group chats"}`, http.StatusBadRequest)
+		return
+	}
+
+	target, err := chatMembership(chatID, targetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, `{"error":"User is not a member of this chat"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if !outranks(member, memberRole(target)) || roleRank[input.Role] > roleRank[memberRole(member)] {
+		http.Error(w, `{"error":"You cannot assign this role to this member"}`, http.StatusForbidden)
+		return
+	}
+
+	oldRole := memberRole(target)
+	if err := database.DB.Model(target).Update("role", input.Role).Error; err != nil {
+		http.Error(w, `{"error":"Failed to update role"}`, http.StatusInternalServerError)
+		return
+	}
+
+	publishChatEvent(chatID, realtime.MemberRoleUpdated, map[string]interface{}{
+		"user_id":    targetID,
+		"role":       input.Role,
+		"updated_by": userID,
+	})
+	postSystemMessage(chatID, models.SystemEvent{
+		Action:    models.SystemRoleChanged,
+		ActorID:   userID,
+		TargetIDs: []uint{targetID},
+		OldValue:  oldRole,
+		NewValue:  input.Role,
+	})
+
+	json.NewEncoder(w).Encode(target)
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// setupRoleChat creates a group chat owned by user 1 where user 2 holds
+// role and user 3 is a plain member who has sent one message. User 4 is not
+// in the chat.
+func setupRoleChat(t *testing.T, role string) (models.Chat, models.Message) {
+	t.Helper()
+	db := setupTestDB(t, "owner", "actor", "member", "outsider")
+	chat := createChat(t, db, 1, 2, 3)
+	for userID, r := range map[uint]string{1: models.RoleOwner, 2: role, 3: models.RoleMember} {
+		db.Model(&models.ChatMember{}).Where("chat_id = ? AND user_id = ?", chat.ID, userID).Update("role", r)
+	}
+	return chat, sendTestMessage(t, db, chat.ID, 3, "hello", time.Now())
+}
+
+func TestRolePermissionMatrix(t *testing.T) {
+	type action struct {
+		handler http.HandlerFunc
+		method  string
+		param   string // route variable for the chat, or the message when deleting one
+		body    string
+		allowed int
+	}
+	actions := map[string]action{
+		"rename chat":            {UpdateChat, "PUT", "id", `{"name":"renamed"}`, http.StatusOK},
+		"change description":     {UpdateChat, "PUT", "id", `{"description":"about"}`, http.StatusOK},
+		"add members":            {AddUserToGroupChat, "POST", "chat_id", `{"user_ids":[4]}`, http.StatusOK},
+		"remove members":         {RemoveUserFromGroupChat, "DELETE", "chat_id", `{"user_ids":[3]}`, http.StatusOK},
+		"delete others' message": {DeleteMessage, "DELETE", "id", "", http.StatusOK},
+		"send messages":          {SendMultipleMessages, "POST", "chat_id", `[{"text":"hi"}]`, http.StatusCreated},
+		"delete chat":            {DeleteChat, "DELETE", "id", "", http.StatusOK},
+	}
+	// Which actions each role may take; everything else is 403
+	grants := map[string][]string{
+		models.RoleOwner:     {"rename chat", "change description", "add members", "remove members", "delete others' message", "send messages", "delete chat"},
+		models.RoleAdmin:     {"rename chat", "change description", "add members", "remove members", "delete others' message", "send messages"},
+		models.RoleModerator: {"delete others' message", "send messages"},
+		models.RoleMember:    {"send messages"},
+		models.RoleReadOnly:  {},
+	}
+
+	for role, granted := range grants {
+		allowed := map[string]bool{}
+		for _, name := range granted {
+			allowed[name] = true
+		}
+		for name, a := range actions {
+			t.Run(role+"/"+name, func(t *testing.T) {
+				chat, msg := setupRoleChat(t, role)
+				id := chat.ID
+				if name == "delete others' message" {
+					id = msg.ID
+				}
+				want := http.StatusForbidden
+				if allowed[name] {
+					want = a.allowed
+				}
+				rec := call(a.handler, a.method, 2, map[string]string{a.param: fmt.Sprint(id)}, a.body)
+				if rec.Code != want {
+					t.Fatalf("got %d, want %d: %s", rec.Code, want, rec.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateMemberRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		callerRole string
+		target     uint // user whose role changes
+		targetRole string
+		role       string
+		status     int
+	}{
+		{"owner promotes member to admin", models.RoleOwner, 3, models.RoleMember, models.RoleAdmin, http.StatusOK},
+		{"admin promotes member to admin", models.RoleAdmin, 3, models.RoleMember, models.RoleAdmin, http.StatusOK},
+		{"admin demotes moderator", models.RoleAdmin, 3, models.RoleModerator, models.RoleReadOnly, http.StatusOK},
+		{"admin cannot demote another admin", models.RoleAdmin, 3, models.RoleAdmin, models.RoleMember, http.StatusForbidden},
+		{"admin cannot demote the owner", models.RoleAdmin, 1, models.RoleOwner, models.RoleMember, http.StatusForbidden},
+		{"moderator cannot manage roles", models.RoleModerator, 3, models.RoleMember, models.RoleReadOnly, http.StatusForbidden},
+		{"ownership is not a role to grant", models.RoleOwner, 3, models.RoleMember, models.RoleOwner, http.StatusBadRequest},
+		{"unknown role", models.RoleOwner, 3, models.RoleMember, "superuser", http.StatusBadRequest},
+		{"own role", models.RoleAdmin, 2, models.RoleAdmin, models.RoleModerator, http.StatusForbidden},
+		{"not a member", models.RoleOwner, 4, "", models.RoleMember, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat, _ := setupRoleChat(t, tt.callerRole)
+			if tt.target == 3 {
+				setRole(t, chat.ID, 3, tt.targetRole)
+			}
+			vars := map[string]string{"chat_id": fmt.Sprint(chat.ID), "user_id": fmt.Sprint(tt.target)}
+			rec := call(UpdateMemberRole, "PUT", 2, vars, fmt.Sprintf(`{"role":%q}`, tt.role))
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK && memberRole(mustMember(t, chat.ID, tt.target)) != tt.role {
+				t.Fatalf("role not changed to %s", tt.role)
+			}
+		})
+	}
+}
+
+func setRole(t *testing.T, chatID, userID uint, role string) {
+	t.Helper()
+	member := mustMember(t, chatID, userID)
+	if err := database.DB.Model(member).Update("role", role).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustMember(t *testing.T, chatID, userID uint) *models.ChatMember {
+	t.Helper()
+	member, err := chatMembership(chatID, userID)
+	if err != nil {
+		t.Fatalf("membership of user %d: %v", userID, err)
+	}
+	return member
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/storage"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sqlitedriver "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SQLite's LIKE has no escape character unless one is given, while MySQL
+// uses backslash by default. Replacing like() lets escapeLike patterns
+// behave in tests as they do in production.
+func init() {
+	sqlitedriver.MustRegisterDeterministicScalarFunction("like", 2, func(_ *sqlitedriver.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, ok1 := args[0].(string)
+		text, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		return mysqlLike(pattern).MatchString(text), nil
+	})
+}
+
+// mysqlLike compiles a LIKE pattern, with "\" escaping the next character,
+// into a case-insensitive regexp
+func mysqlLike(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString(`(?is)^`)
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case c == '%':
+			expr.WriteString(`.*`)
+		case c == '_':
+			expr.WriteString(`.`)
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString(`$`)
+	return regexp.MustCompile(expr.String())
+}
+
+// setupTestDB points database.DB at a throwaway database with the schema
+// migrated and the given users created, with IDs 1, 2, ... in order.
+// storage.Default is pointed at an empty directory.
+func setupTestDB(t *testing.T, users ...string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/chat.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.Reaction{}, &models.ChatMember{}, &models.MessageStatus{}, &models.RealtimeEvent{}, &models.RealtimeSignal{}, &models.MessageRevision{}, &models.HiddenMessage{}, &models.Attachment{}, &models.AttachmentThumbnail{}, &models.Poll{}, &models.PollOption{}, &models.PollVote{}, &models.PinnedMessage{}, &models.StarredMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevStore := database.DB, storage.Default
+	t.Cleanup(func() { database.DB, storage.Default = prevDB, prevStore })
+	database.DB = db
+	if storage.Default, err = storage.NewLocalStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range users {
+		if err := db.Create(&models.User{Name: name, Email: name + "@example.com"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// createChat adds a group chat of memberIDs, created by the first of them
+func createChat(t *testing.T, db *gorm.DB, memberIDs ...uint) models.Chat {
+	t.Helper()
+	chat := models.Chat{Name: "team", IsGroup: true, CreatedBy: memberIDs[0]}
+	if err := db.Create(&chat).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range memberIDs {
+		if err := db.Create(&models.ChatMember{ChatID: chat.ID, UserID: userID}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return chat
+}
+
+// sendTestMessage stores a message from senderID at the given time, with a
+// "sent" status row for every other member
+func sendTestMessage(t *testing.T, db *gorm.DB, chatID, senderID uint, text string, at time.Time) models.Message {
+	t.Helper()
+	msg := models.Message{ChatID: chatID, SenderID: senderID, Text: text, Type: "text", CreatedAt: at}
+	if err := db.Create(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	var members []models.ChatMember
+	db.Where("chat_id = ? AND user_id <> ?", chatID, senderID).Find(&members)
+	for _, m := range members {
+		if err := db.Create(&models.MessageStatus{MessageID: msg.ID, UserID: m.UserID, ChatMemberID: m.ID, Status: "sent", SentAt: &at}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msg
+}
+
+// call runs handler as userID with the given route variables and JSON body
+func call(handler http.HandlerFunc, method string, userID uint, vars map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), userIDKey, userID))
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// withQuery sets the query string of the requests handler receives
+func withQuery(handler http.HandlerFunc, query string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.URL.RawQuery = query
+		handler(w, r)
+	}
+}
+
+// decodeBody decodes a JSON response into v, failing the test unless the
+// status is want
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, want int, v interface{}) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("got %d, want %d: %s", rec.Code, want, rec.Body)
+	}
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limits on the note and tags of a star
+const (
+	maxStarNoteLength = 1000
+	maxStarTags       = 10
+	maxStarTagLength  = 32
+)
+
+// starredCursor continues GetStarredMessages below a star ID
+type starredCursor struct {
+	Before uint `json:"b"`
+}
+
+// starredEntry is one star in the GetStarredMessages response
+type starredEntry struct {
+	models.StarredMessage
+	ChatName string         `json:"chat_name"`
+	Message  models.Message `json:"message"`
+}
+
+// cleanTags lower-cases, trims and de-duplicates star tags. Tags are limited
+// to letters, digits, "-" and "_".
+func cleanTags(tags []string) ([]string, error) {
+	cleaned := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxStarTagLength || strings.IndexFunc(tag, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+		}) >= 0 {
+			return nil, fmt.Errorf("Tags must be up to %d letters, digits, '-' or '_'", maxStarTagLength)
+		}
+		seen[tag] = true
+		cleaned = append(cleaned, tag)
+	}
+	if len(cleaned) > maxStarTags {
+		return nil, fmt.Errorf("A star can have at most %d tags", maxStarTags)
+	}
+	return cleaned, nil
+}
+
+// StarMessage saves a message for the caller. Starring an already starred
+// message updates whichever of note and tags the request includes.
+func StarMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, msg, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if msg.Type == models.MessageTypeSystem {
+		http.Error(w, `{"error":"System messages cannot be starred"}`, http.StatusBadRequest)
+		return
+	}
+	if msg.DeletedForEveryoneAt != nil {
+		http.Error(w, `{"error":"Deleted messages cannot be starred"}`, http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		Note *string   `json:"note"`
+		Tags *[]string `json:"tags"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, `{"error":"Invalid JSON"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	star := models.StarredMessage{UserID: userID, MessageID: msg.ID, ChatID: msg.ChatID}
+	// Only the fields the request includes are overwritten on an existing star
+	updates := []string{"updated_at"}
+	if input.Note != nil {
+		note := strings.TrimSpace(*input.Note)
+		if utf8.RuneCountInString(note) > maxStarNoteLength {
+			http.Error(w, fmt.Sprintf(`{"error":"Notes are limited to %d characters"}`, maxStarNoteLength), http.StatusBadRequest)
+			return
+		}
+		star.Note = note
+		updates = append(updates, "note")
+	}
+	if input.Tags != nil {
+		tags, err := cleanTags(*input.Tags)
+		if err != nil {
+			http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+			return
+		}
+		star.Tags = tags
+		updates = append(updates, "tags")
+	}
+
+	var existing int64
+	if err := database.DB.Model(&models.StarredMessage{}).Where("user_id = ? AND message_id = ?", userID, msg.ID).Count(&existing).Error; err != nil {
+		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		return
+	}
+	created := existing == 0
+
+	// Upsert, so starring twice at once cannot fail on the unique index
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "message_id"}},
+			DoUpdates: clause.AssignmentColumns(updates),
+		}).Create(&star).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND message_id = ?", userID, msg.ID).First(&star).Error
+	})
+	if err != nil {
+		http.Error(w, `{"error":"Failed to star message"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(star)
+}
+
+// UnstarMessage removes the caller's star from a message. It works after
+// leaving the chat, so stale stars can still be cleared.
+func UnstarMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	messageID, ok := pathID(w, r, "id", "message")
+	if !ok {
+		return
+	}
+
+	result := database.DB.Where("user_id = ? AND message_id = ?", userID, messageID).Delete(&models.StarredMessage{})
+	if result.Error != nil {
+		http.Error(w, `{"error":"Failed to unstar message"}`, http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, `{"error":"Message is not starred"}`, http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Message unstarred",
+	})
+}
+
+// GetStarredMessages lists the caller's starred messages, most recently
+// starred first, across the chats they still belong to. Results can be
+// narrowed with ?chat_id= and ?tag=, and are continued with
+// ?cursor=next_cursor.
+func GetStarredMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	limit := parseLimit(r, 50, 200)
+	query := database.DB.
+		Where("user_id = ?", userID).
+		Where("chat_id IN (?)", database.DB.Model(&models.ChatMember{}).Select("chat_id").Where("user_id = ?", userID)).
+		Where("message_id NOT IN (?)", database.DB.Model(&models.HiddenMessage{}).Select("message_id").Where("user_id = ?", userID)).
+		Order("id DESC").
+		Limit(limit + 1)
+
+	if v := r.URL.Query().Get("chat_id"); v != "" {
+		chatID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid chat ID"}`, http.StatusBadRequest)
+			return
+		}
+		query = query.Where("chat_id = ?", chatID)
+	}
+	if tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag"))); tag != "" {
+		// Tags are stored as a JSON array; match the quoted tag with its LIKE
+		// wildcards escaped, as "_" is allowed in tags
+		query = query.Where("tags LIKE ?", "%"+escapeLike(`"`+tag+`"`)+"%")
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var pos starredCursor
+		if err := decodeCursor(cursor, &pos); err != nil {
+			http.Error(w, `{"error":"Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+		query = query.Where("id < ?", pos.Before)
+	}
+
+	var stars []models.StarredMessage
+	if err := query.Find(&stars).Error; err != nil {
+		http.Error(w, `{"error":"Failed to load starred messages"}`, http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(stars) > limit
+	if hasMore {
+		stars = stars[:limit]
+	}
+
+	messageIDs := make([]uint, 0, len(stars))
+	chatIDs := make([]uint, 0, len(stars))
+	for _, s := range stars {
+		messageIDs = append(messageIDs, s.MessageID)
+		chatIDs = append(chatIDs, s.ChatID)
+	}
+
+	var messages []models.Message
+	var chats []models.Chat
+	if len(stars) > 0 {
+		if err := database.DB.
+			Preload("Sender").
+			Preload("Reactions").
+			Preload("Attachments.Thumbnails").
+			Scopes(withPoll).
+			Where("id IN ?", messageIDs).
+			Find(&messages).Error; err != nil {
+			http.Error(w, `{"error":"Failed to load starred messages"}`, http.StatusInternalServerError)
+			return
+		}
+		if err := database.DB.Select("id", "name").Where("id IN ?", chatIDs).Find(&chats).Error; err != nil {
+			http.Error(w, `{"error":"Failed to load chats"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+	decorateMessages(messages)
+	byID := make(map[uint]models.Message, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+	chatNames := make(map[uint]string, len(chats))
+	for _, c := range chats {
+		chatNames[c.ID] = c.Name
+	}
+
+	entries := make([]starredEntry, 0, len(stars))
+	for _, s := range stars {
+		if m, found := byID[s.MessageID]; found {
+			entries = append(entries, starredEntry{StarredMessage: s, ChatName: chatNames[s.ChatID], Message: m})
+		}
+	}
+
+	resp := map[string]interface{}{
+		"starred":  entries,
+		"has_more": hasMore,
+	}
+	if hasMore {
+		resp["next_cursor"] = encodeCursor(starredCursor{Before: stars[len(stars)-1].ID})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// starredResponse is the body of GetStarredMessages
+type starredResponse struct {
+	Starred []struct {
+		MessageID uint     `json:"message_id"`
+		ChatID    uint     `json:"chat_id"`
+		Note      string   `json:"note"`
+		Tags      []string `json:"tags"`
+	} `json:"starred"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// star stars msg as userID with the given JSON body
+func star(t *testing.T, userID uint, msg models.Message, body string) {
+	t.Helper()
+	rec := call(StarMessage, "POST", userID, map[string]string{"id": fmt.Sprint(msg.ID)}, body)
+	if rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+		t.Fatalf("star %d: got %d: %s", msg.ID, rec.Code, rec.Body)
+	}
+}
+
+// starredIDs lists userID's starred message IDs for the given query
+func starredIDs(t *testing.T, userID uint, query string) ([]uint, starredResponse) {
+	t.Helper()
+	var resp starredResponse
+	decodeBody(t, call(withQuery(GetStarredMessages, query), "GET", userID, nil, ""), http.StatusOK, &resp)
+	ids := make([]uint, 0, len(resp.Starred))
+	for _, s := range resp.Starred {
+		ids = append(ids, s.MessageID)
+	}
+	return ids, resp
+}
+
+func TestStarMessage(t *testing.T) {
+	db := setupTestDB(t, "owner", "member")
+	chat := createChat(t, db, 1, 2)
+	msg := sendTestMessage(t, db, chat.ID, 1, "hello", time.Now())
+	vars := map[string]string{"id": fmt.Sprint(msg.ID)}
+
+	rec := call(StarMessage, "POST", 2, vars, `{"note":" later ","tags":["Work","work"," todo "]}`)
+	var created models.StarredMessage
+	decodeBody(t, rec, http.StatusCreated, &created)
+	if created.Note != "later" || fmt.Sprint(created.Tags) != "[work todo]" {
+		t.Fatalf("got note %q tags %v", created.Note, created.Tags)
+	}
+
+	// Starring again keeps the fields the request leaves out
+	var updated models.StarredMessage
+	decodeBody(t, call(StarMessage, "POST", 2, vars, `{"tags":["home"]}`), http.StatusOK, &updated)
+	if updated.ID != created.ID || updated.Note != "later" || fmt.Sprint(updated.Tags) != "[home]" {
+		t.Fatalf("got %+v", updated)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"tag with space", `{"tags":["two words"]}`},
+		{"tag too long", fmt.Sprintf(`{"tags":[%q]}`, fmt.Sprintf("%0*d", maxStarTagLength+1, 0))},
+		{"too many tags", `{"tags":["a","b","c","d","e","f","g","h","i","j","k"]}`},
+		{"invalid JSON", `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := call(StarMessage, "POST", 2, vars, tt.body); rec.Code != http.StatusBadRequest {
+				t.Fatalf("got %d, want 400", rec.Code)
+			}
+		})
+	}
+
+	decodeBody(t, call(UnstarMessage, "DELETE", 2, vars, ""), http.StatusOK, nil)
+	if rec := call(UnstarMessage, "DELETE", 2, vars, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second unstar: got %d, want 404", rec.Code)
+	}
+}
+
+func TestGetStarredMessagesFilters(t *testing.T) {
+	db := setupTestDB(t, "owner", "member")
+	chat := createChat(t, db, 1, 2)
+	other := createChat(t, db, 1, 2)
+	todo := sendTestMessage(t, db, chat.ID, 1, "one", time.Now())
+	lookalike := sendTestMessage(t, db, chat.ID, 1, "two", time.Now())
+	elsewhere := sendTestMessage(t, db, other.ID, 1, "three", time.Now())
+	star(t, 2, todo, `{"tags":["to_do"]}`)
+	star(t, 2, lookalike, `{"tags":["toxdo"]}`)
+	star(t, 2, elsewhere, `{"tags":["to_do","later"]}`)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []uint
+	}{
+		{"all", "", []uint{elsewhere.ID, lookalike.ID, todo.ID}},
+		{"chat", fmt.Sprint("chat_id=", chat.ID), []uint{lookalike.ID, todo.ID}},
+		// "_" is matched literally, not as a LIKE wildcard
+		{"tag with underscore", "tag=to_do", []uint{elsewhere.ID, todo.ID}},
+		{"tag is case-insensitive", "tag=LATER", []uint{elsewhere.ID}},
+		{"tag matches whole tags", "tag=to", []uint{}},
+		{"chat and tag", fmt.Sprint("chat_id=", chat.ID, "&tag=toxdo"), []uint{lookalike.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := starredIDs(t, 2, tt.query); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if rec := call(withQuery(GetStarredMessages, "chat_id=x"), "GET", 2, nil, ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid chat_id: got %d, want 400", rec.Code)
+	}
+}
+
+func TestGetStarredMessagesHidesUnreachable(t *testing.T) {
+	db := setupTestDB(t, "owner", "member")
+	chat := createChat(t, db, 1, 2)
+	left := createChat(t, db, 1, 2)
+	kept := sendTestMessage(t, db, chat.ID, 1, "kept", time.Now())
+	hidden := sendTestMessage(t, db, chat.ID, 1, "hidden", time.Now())
+	gone := sendTestMessage(t, db, left.ID, 1, "gone", time.Now())
+	for _, msg := range []models.Message{kept, hidden, gone} {
+		star(t, 2, msg, "")
+	}
+
+	if err := db.Create(&models.HiddenMessage{MessageID: hidden.ID, UserID: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("chat_id = ? AND user_id = ?", left.ID, 2).Delete(&models.ChatMember{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := starredIDs(t, 2, ""); fmt.Sprint(got) != fmt.Sprint([]uint{kept.ID}) {
+		t.Fatalf("got %v, want [%d]", got, kept.ID)
+	}
+
+	// Stars in a chat the caller left can still be removed
+	decodeBody(t, call(UnstarMessage, "DELETE", 2, map[string]string{"id": fmt.Sprint(gone.ID)}, ""), http.StatusOK, nil)
+	var count int64
+	database.DB.Model(&models.StarredMessage{}).Where("message_id = ?", gone.ID).Count(&count)
+	if count != 0 {
+		t.Fatal("star of a left chat was not removed")
+	}
+}
+
+func TestGetStarredMessagesPaging(t *testing.T) {
+	db := setupTestDB(t, "owner", "member")
+	chat := createChat(t, db, 1, 2)
+	var want []uint
+	for i := 0; i < 5; i++ {
+		msg := sendTestMessage(t, db, chat.ID, 1, fmt.Sprint("m", i), time.Now())
+		star(t, 2, msg, "")
+		want = append([]uint{msg.ID}, want...)
+	}
+
+	var got []uint
+	query := "limit=2"
+	for page := 0; ; page++ {
+		if page > len(want) {
+			t.Fatal("paging did not end")
+		}
+		ids, resp := starredIDs(t, 2, query)
+		got = append(got, ids...)
+		if !resp.HasMore {
+			break
+		}
+		query = "limit=2&cursor=" + resp.NextCursor
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if rec := call(withQuery(GetStarredMessages, "cursor=!"), "GET", 2, nil, ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid cursor: got %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// postSystemMessage records a chat event in the chat history and pushes it to
+// connected members. System messages get no receipt rows, so they never count
+// as unread.
+func postSystemMessage(chatID uint, event models.SystemEvent) {
+	msg := models.Message{
+		ChatID:    chatID,
+		SenderID:  event.ActorID,
+		Type:      models.MessageTypeSystem,
+		System:    &event,
+		CreatedAt: time.Now(),
+	}
+	msg.Text = renderSystemEvent(event, userNames(systemEventUserIDs(event)))
+	if err := database.DB.Create(&msg).Error; err != nil {
+		return
+	}
+	updateChatMetadata(chatID)
+	publishChatEvent(chatID, realtime.MessageCreated, msg)
+}
+
+// renderSystemMessages rewrites the text of system messages from their
+// payloads so they read the same everywhere, using current user names
+func renderSystemMessages(messages []models.Message) {
+	var ids []uint
+	for _, msg := range messages {
+		if msg.System != nil {
+			ids = append(ids, systemEventUserIDs(*msg.System)...)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	names := userNames(ids)
+	for i := range messages {
+		if messages[i].System != nil {
+			messages[i].Text = renderSystemEvent(*messages[i].System, names)
+		}
+	}
+}
+
+// renderSystemEvent turns a system event into a line of chat history
+func renderSystemEvent(event models.SystemEvent, names map[uint]string) string {
+	name := func(id uint) string {
+		if n := names[id]; n != "" {
+			return n
+		}
+		return "A user"
+	}
+	targets := make([]string, len(event.TargetIDs))
+	for i, id := range event.TargetIDs {
+		targets[i] = name(id)
+	}
+	actor := name(event.ActorID)
+
+	switch event.Action {
+	case models.SystemChatCreated:
+		if event.NewValue == "" {
+			return actor + " created the chat"
+		}
+		return fmt.Sprintf("%s created the chat %q", actor, event.NewValue)
+	case models.SystemMembersAdded:
+		return actor + " added " + strings.Join(targets, ", ")
+	case models.SystemMembersRemoved:
+		return actor + " removed " + strings.Join(targets, ", ")
+	case models.SystemMemberLeft:
+		return actor + " left the chat"
+	case models.SystemChatRenamed:
+		return fmt.Sprintf("%s renamed the chat from %q to %q", actor, event.OldValue, event.NewValue)
+	case models.SystemDescriptionChanged:
+		return actor + " changed the chat description"
+	case models.SystemOwnerChanged:
+		return strings.Join(targets, ", ") + " is now the owner"
+	case models.SystemRoleChanged:
+		if event.ActorID == 0 {
+			// Automatic promotion after the last admin left
+			return fmt.Sprintf("%s's role changed from %s to %s", strings.Join(targets, ", "), event.OldValue, event.NewValue)
+		}
+		return fmt.Sprintf("%s changed %s's role from %s to %s", actor, strings.Join(targets, ", "), event.OldValue, event.NewValue)
+	case models.SystemMessagePinned:
+		if event.NewValue == "" {
+			return actor + " pinned a message"
+		}
+		return fmt.Sprintf("%s pinned %q", actor, event.NewValue)
+	}
+	return ""
+}
+
+// systemEventUserIDs lists the users named by a system event
+func systemEventUserIDs(event models.SystemEvent) []uint {
+	return append([]uint{event.ActorID}, event.TargetIDs...)
+}
+
+// userNames batch-loads display names for ids
+func userNames(ids []uint) map[uint]string {
+	names := make(map[uint]string, len(ids))
+	var users []models.User
+	database.DB.Unscoped().Select("id", "name").Where("id IN ?", ids).Find(&users)
+	for _, user := range users {
+		names[user.ID] = user.Name
+	}
+	return names
+}
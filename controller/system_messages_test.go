@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestClientsCannotSendSystemMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"SendMessage", SendMessage, `{"chat_id":%d,"text":"fake","type":"system"}`},
+		{"SendMultipleMessages", SendMultipleMessages, `[{"text":"ok"},{"text":"fake","type":"system"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, "owner", "member")
+			chat := createChat(t, db, 1, 2)
+			rec := call(tt.handler, "POST", 2, map[string]string{"chat_id": fmt.Sprint(chat.ID)}, fmt.Sprintf(tt.body, chat.ID))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got %d, want 400: %s", rec.Code, rec.Body)
+			}
+			var count int64
+			db.Model(&models.Message{}).Count(&count)
+			if count != 0 {
+				t.Fatalf("%d messages stored", count)
+			}
+		})
+	}
+}
+
+func TestLeaveChatPostsSystemMessage(t *testing.T) {
+	chat, _ := setupRoleChat(t, models.RoleAdmin)
+	if rec := call(LeaveChat, "POST", 3, map[string]string{"chat_id": fmt.Sprint(chat.ID)}, ""); rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+
+	var msg models.Message
+	if err := database.DB.Where("chat_id = ? AND type = ?", chat.ID, models.MessageTypeSystem).First(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	if msg.System == nil || msg.System.Action != models.SystemMemberLeft || msg.System.ActorID != 3 {
+		t.Fatalf("unexpected system event %+v", msg.System)
+	}
+	if msg.Text != "member left the chat" {
+		t.Fatalf("rendered as %q", msg.Text)
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetThread lists the replies to a message in chronological order. Pages are
+// continued with ?cursor=next_cursor or ?after=<reply id>.
+func GetThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, parent, _, ok := authorizeMessageParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	limit := parseLimit(r, 50, 200)
+	var anchor messageCursor
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		if err := decodeCursor(cursor, &anchor); err != nil {
+			http.Error(w, `{"error":"Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+	} else if v := r.URL.Query().Get("after"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid after message ID"}`, http.StatusBadRequest)
+			return
+		}
+		anchor.After = uint(id)
+	}
+
+	var replies []models.Message
+	if err := database.DB.
+		Preload("Sender").
+		Preload("Reactions").
+		Preload("Attachments.Thumbnails").
+		Scopes(withPoll).
+		Scopes(visibleTo(userID)).
+		Where("reply_to_id = ? AND id > ?", parent.ID, anchor.After).
+		Order("id ASC").
+		Limit(limit + 1).
+		Find(&replies).Error; err != nil {
+		http.Error(w, `{"error":"Failed to load thread"}`, http.StatusInternalServerError)
+		return
+	}
+
+	hasMore := len(replies) > limit
+	if hasMore {
+		replies = replies[:limit]
+	}
+
+	database.DB.Preload("Sender").Preload("Reactions").Preload("Attachments.Thumbnails").Scopes(withPoll).First(parent, parent.ID)
+	thread := append([]models.Message{*parent}, replies...)
+	decorateMessages(thread)
+
+	resp := map[string]interface{}{
+		"parent":   thread[0],
+		"replies":  thread[1:],
+		"has_more": hasMore,
+	}
+	if hasMore {
+		resp["next_cursor"] = encodeCursor(messageCursor{After: replies[len(replies)-1].ID})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// validReplyTarget reports whether parentID is a message in chatID that can
+// be replied to
+func validReplyTarget(chatID, parentID uint) bool {
+	var parent models.Message
+	if err := database.DB.Select("id", "chat_id", "type", "deleted_for_everyone_at").First(&parent, parentID).Error; err != nil {
+		return false
+	}
+	return parent.ChatID == chatID && parent.Type != models.MessageTypeSystem && parent.DeletedForEveryoneAt == nil
+}
+
+// updateThreadStats recomputes a parent message's reply count and last reply
+// time and tells connected members
+func updateThreadStats(parentID uint) {
+	var parent models.Message
+	if err := database.DB.Select("id", "chat_id").First(&parent, parentID).Error; err != nil {
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.Message{}).Where("reply_to_id = ?", parentID).Count(&count)
+
+	var lastReplyAt *time.Time
+	var last models.Message
+	if err := database.DB.Select("id", "created_at").
+		Where("reply_to_id = ?", parentID).
+		Order("id DESC").
+		First(&last).Error; err == nil {
+		lastReplyAt = &last.CreatedAt
+	}
+
+	// UpdateColumns leaves updated_at alone; the parent itself was not edited
+	database.DB.Model(&parent).UpdateColumns(map[string]interface{}{
+		"reply_count":   count,
+		"last_reply_at": lastReplyAt,
+	})
+
+	publishChatEvent(parent.ChatID, realtime.ThreadUpdated, map[string]interface{}{
+		"message_id":    parent.ID,
+		"reply_count":   count,
+		"last_reply_at": lastReplyAt,
+	})
+}
+
+// attachReplyPreviews fills in the quoted parent of each reply
+func attachReplyPreviews(messages []models.Message) {
+	var parentIDs []uint
+	for _, msg := range messages {
+		if msg.ReplyToID != nil {
+			parentIDs = append(parentIDs, *msg.ReplyToID)
+		}
+	}
+	if len(parentIDs) == 0 {
+		return
+	}
+
+	var parents []models.Message
+	database.DB.Preload("Sender").Where("id IN ?", parentIDs).Find(&parents)
+	renderSystemMessages(parents)
+	previews := make(map[uint]*models.MessagePreview, len(parents))
+	for _, parent := range parents {
+		previews[parent.ID] = newMessagePreview(parent)
+	}
+	for i := range messages {
+		if messages[i].ReplyToID != nil {
+			messages[i].ReplyPreview = previews[*messages[i].ReplyToID]
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/media"
+	"ChatApiServer/models"
+	"ChatApiServer/realtime"
+	"ChatApiServer/storage"
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// thumbnailSizes are the previews generated for each image, by the longest
+// side in pixels. Sizes not smaller than the original are skipped.
+var thumbnailSizes = []struct {
+	Name    string
+	MaxSide int
+}{
+	{"small", 96},
+	{"medium", 320},
+	{"large", 800},
+}
+
+const (
+	// How often images still waiting for thumbnails are requeued
+	thumbnailSweepInterval = time.Minute
+
+	// How long a worker may hold an image before it is given to another
+	// one, e.g. because the instance rendering it stopped
+	thumbnailClaimTimeout = 10 * time.Minute
+)
+
+// thumbnailQueue holds IDs of attachments waiting for thumbnails
+var thumbnailQueue = make(chan uint, 256)
+
+// StartThumbnailWorker starts background workers that generate thumbnails
+// for uploaded images, and a sweeper that requeues images left pending or
+// unfinished. Call once after InitDB and after storage.Default is set.
+func StartThumbnailWorker(workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range thumbnailQueue {
+				generateThumbnails(id)
+			}
+		}()
+	}
+
+	go func() {
+		sweepThumbnails(time.Now())
+		ticker := time.NewTicker(thumbnailSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			sweepThumbnails(now)
+		}
+	}()
+}
+
+// sweepThumbnails releases claims older than thumbnailClaimTimeout and
+// queues images that have been pending for a sweep interval, such as ones
+// dropped from a full queue, as far as the queue has room. It returns how
+// many were queued. Should a worker whose claim was released still finish,
+// whichever run commits second is rolled back and removes only its own files.
+func sweepThumbnails(now time.Time) int {
+	if err := database.DB.Model(&models.Attachment{}).
+		Where("thumbnail_status = ? AND (thumbnail_claimed_at IS NULL OR thumbnail_claimed_at < ?)",
+			models.ThumbnailProcessing, now.Add(-thumbnailClaimTimeout)).
+		Updates(map[string]interface{}{
+			"thumbnail_status":     models.ThumbnailPending,
+			"thumbnail_claimed_at": nil,
+		}).Error; err != nil {
+		log.Printf("Failed to release stale thumbnail claims: %v", err)
+		return 0
+	}
+
+	room := cap(thumbnailQueue) - len(thumbnailQueue)
+	if room == 0 {
+		return 0
+	}
+	var ids []uint
+	if err := database.DB.Model(&models.Attachment{}).
+		Where("thumbnail_status = ? AND created_at < ?", models.ThumbnailPending, now.Add(-thumbnailSweepInterval)).
+		Order("id ASC").
+		Limit(room).
+		Pluck("id", &ids).Error; err != nil {
+		log.Printf("Failed to look for pending thumbnails: %v", err)
+		return 0
+	}
+	queued := 0
+	for _, id := range ids {
+		if queueThumbnails(id) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// queueThumbnails schedules thumbnail generation for an attachment and
+// reports whether it was queued. When the queue is full the attachment stays
+// pending until a later sweep.
+func queueThumbnails(attachmentID uint) bool {
+	select {
+	case thumbnailQueue <- attachmentID:
+		return true
+	default:
+		log.Printf("Thumbnail queue full, attachment %d left pending", attachmentID)
+		return false
+	}
+}
+
+// generateThumbnails writes every thumbnail size and the blurhash for an
+// image attachment
+func generateThumbnails(attachmentID uint) {
+	// Claim the attachment so it is rendered once, however often it was queued
+	claim := database.DB.Model(&models.Attachment{}).
+		Where("id = ? AND thumbnail_status = ?", attachmentID, models.ThumbnailPending).
+		Updates(map[string]interface{}{
+			"thumbnail_status":     models.ThumbnailProcessing,
+			"thumbnail_claimed_at": time.Now(),
+		})
+	if claim.Error != nil || claim.RowsAffected != 1 {
+		return
+	}
+
+	var attachment models.Attachment
+	if err := database.DB.First(&attachment, attachmentID).Error; err != nil {
+		return
+	}
+
+	thumbnails, blurhash, err := renderThumbnails(attachment)
+	if err != nil {
+		log.Printf("Failed to generate thumbnails for attachment %d: %v", attachmentID, err)
+		database.DB.Model(&attachment).
+			Where("thumbnail_status = ?", models.ThumbnailProcessing).
+			Update("thumbnail_status", models.ThumbnailFailed)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// The message may have been deleted while we were working
+		result := tx.Model(&attachment).
+			Where("thumbnail_status = ?", models.ThumbnailProcessing).
+			Updates(map[string]interface{}{
+				"thumbnail_status": models.ThumbnailReady,
+				"blurhash":         blurhash,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if len(thumbnails) == 0 {
+			return nil
+		}
+		return tx.Create(&thumbnails).Error
+	})
+	if err != nil {
+		// These keys were made for this run, so no other run can be using them
+		for _, t := range thumbnails {
+			storage.Default.Delete(t.StorageKey)
+		}
+		return
+	}
+
+	// Sent attachments are announced so clients can swap in the previews;
+	// pending uploads pick them up when the message is sent
+	database.DB.Select("message_id").First(&attachment, attachment.ID)
+	if attachment.MessageID != nil {
+		attachment.ThumbnailStatus = models.ThumbnailReady
+		attachment.Blurhash = blurhash
+		attachment.Thumbnails = thumbnails
+		publishChatEvent(attachment.ChatID, realtime.AttachmentProcessed, attachment)
+	}
+}
+
+// renderThumbnails decodes an image attachment and stores a scaled copy for
+// each thumbnail size next to the original, under keys unique to this call
+func renderThumbnails(attachment models.Attachment) ([]models.AttachmentThumbnail, string, error) {
+	if attachment.Width*attachment.Height > media.MaxPixels {
+		return nil, "", fmt.Errorf("image is %dx%d, too large to decode", attachment.Width, attachment.Height)
+	}
+
+	blob, err := storage.Default.Open(attachment.StorageKey)
+	if err != nil {
+		return nil, "", err
+	}
+	img, _, err := image.Decode(blob)
+	blob.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var thumbnails []models.AttachmentThumbnail
+	for _, size := range thumbnailSizes {
+		if attachment.Width <= size.MaxSide && attachment.Height <= size.MaxSide {
+			break
+		}
+		scaled := media.Fit(img, size.MaxSide)
+		data, mimeType, err := media.Encode(scaled)
+		var key string
+		if err == nil {
+			key, err = newStorageKey(attachment.ChatID)
+		}
+		if err == nil {
+			key += "_" + size.Name
+			_, err = storage.Default.Put(key, bytes.NewReader(data))
+			thumbnails = append(thumbnails, models.AttachmentThumbnail{
+				AttachmentID: attachment.ID,
+				Size:         size.Name,
+				MimeType:     mimeType,
+				Width:        scaled.Bounds().Dx(),
+				Height:       scaled.Bounds().Dy(),
+				Bytes:        int64(len(data)),
+				StorageKey:   key,
+			})
+		}
+		if err != nil {
+			for _, t := range thumbnails {
+				storage.Default.Delete(t.StorageKey)
+			}
+			return nil, "", err
+		}
+	}
+
+	return thumbnails, media.Blurhash(img, 4, 3), nil
+}
+
+// DownloadThumbnail streams one thumbnail size of an image attachment
+func DownloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	// Replaced with the image type once it is served
+	w.Header().Set("Content-Type", "application/json")
+
+	attachment, ok := authorizeAttachment(w, r)
+	if !ok {
+		return
+	}
+
+	size := mux.Vars(r)["size"]
+	var thumbnail models.AttachmentThumbnail
+	if err := database.DB.Where("attachment_id = ? AND size = ?", attachment.ID, size).First(&thumbnail).Error; err != nil {
+		http.Error(w, `{"error":"Thumbnail not found"}`, http.StatusNotFound)
+		return
+	}
+
+	base := strings.TrimSuffix(attachment.FileName, filepath.Ext(attachment.FileName))
+	fileName := fmt.Sprintf("%s_%s.%s", base, size, strings.TrimPrefix(thumbnail.MimeType, "image/"))
+	serveBlob(w, r, thumbnail.StorageKey, thumbnail.MimeType, thumbnail.Bytes, attachment.Checksum+"-"+size, fileName)
+}
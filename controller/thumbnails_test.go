@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"ChatApiServer/database"
+	"ChatApiServer/models"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// pngBytes encodes a w×h opaque image
+func pngBytes(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// drainThumbnailQueue empties the queue, which has no workers in tests
+func drainThumbnailQueue() {
+	for {
+		select {
+		case <-thumbnailQueue:
+		default:
+			return
+		}
+	}
+}
+
+func TestGenerateThumbnails(t *testing.T) {
+	valid := pngBytes(t, 1000, 500)
+	tests := []struct {
+		name   string
+		data   []byte
+		status string
+		sizes  int
+	}{
+		{"large image", valid, models.ThumbnailReady, len(thumbnailSizes)},
+		{"already small", pngBytes(t, 60, 40), models.ThumbnailReady, 0},
+		{"truncated image data", valid[:len(valid)/2], models.ThumbnailFailed, 0},
+		{"malformed header", append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 64)...), "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, "alice")
+			chat := createChat(t, db, 1)
+			defer drainThumbnailQueue()
+
+			attachment, err := storeAttachment(chat.ID, 1, "photo.png", "image/png", bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			generateThumbnails(attachment.ID)
+
+			var got models.Attachment
+			db.Preload("Thumbnails").First(&got, attachment.ID)
+			if got.ThumbnailStatus != tt.status || len(got.Thumbnails) != tt.sizes {
+				t.Fatalf("status %q with %d thumbnails, want %q with %d", got.ThumbnailStatus, len(got.Thumbnails), tt.status, tt.sizes)
+			}
+			if tt.status == models.ThumbnailReady && got.Blurhash == "" {
+				t.Fatal("no blurhash for a rendered image")
+			}
+		})
+	}
+}
+
+func TestSweepThumbnails(t *testing.T) {
+	db := setupTestDB(t, "alice")
+	chat := createChat(t, db, 1)
+	drainThumbnailQueue()
+	defer drainThumbnailQueue()
+
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	stale, fresh := now.Add(-2*thumbnailClaimTimeout), now.Add(-time.Minute)
+	rows := []struct {
+		name      string
+		status    string
+		createdAt time.Time
+		claimedAt *time.Time
+		requeued  bool
+	}{
+		{"abandoned claim", models.ThumbnailProcessing, old, &stale, true},
+		{"claim from before claims were timed", models.ThumbnailProcessing, old, nil, true},
+		{"live claim", models.ThumbnailProcessing, old, &fresh, false},
+		{"dropped from a full queue", models.ThumbnailPending, old, nil, true},
+		{"just uploaded", models.ThumbnailPending, now, nil, false},
+		{"done", models.ThumbnailReady, old, nil, false},
+	}
+	want := map[uint]string{}
+	for _, row := range rows {
+		a := models.Attachment{ChatID: chat.ID, UploaderID: 1, FileName: row.name, ThumbnailStatus: row.status, ThumbnailClaimedAt: row.claimedAt, CreatedAt: row.createdAt}
+		if err := db.Create(&a).Error; err != nil {
+			t.Fatal(err)
+		}
+		if row.requeued {
+			want[a.ID] = row.name
+		}
+	}
+
+	if n := sweepThumbnails(now); n != len(want) {
+		t.Fatalf("queued %d, want %d", n, len(want))
+	}
+	for i := 0; i < len(want); i++ {
+		id := <-thumbnailQueue
+		if _, ok := want[id]; !ok {
+			t.Fatalf("attachment %d queued unexpectedly", id)
+		}
+		var a models.Attachment
+		database.DB.First(&a, id)
+		if a.ThumbnailStatus != models.ThumbnailPending {
+			t.Fatalf("%s: queued with status %q", want[id], a.ThumbnailStatus)
+		}
+		delete(want, id)
+	}
+}
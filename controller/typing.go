@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"ChatApiServer/realtime"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var errNotChatMember = errors.New("Not a member of this chat")
+
+// SetTyping records a typing.start / typing.stop signal for the caller in a
+// chat and relays it to the other members. Typing state is kept in memory and
+// expires on its own after realtime.TypingTTL.
+func SetTyping(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, userID, _, ok := authorizeChatParam(w, r, "chat_id")
+	if !ok {
+		return
+	}
+
+	var input struct {
+		State string `json:"state"` // "start" or "stop"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || (input.State != "start" && input.State != "stop") {
+		http.Error(w, `{"error":"state must be 'start' or 'stop'"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := setTyping(userID, chatID, input.State == "start"); err != nil {
+		http.Error(w, `{"error":"Not a member of this chat"}`, http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setTyping updates the typing store and publishes a change, ignoring
+// refreshes of an already active typing state
+func setTyping(userID, chatID uint, typing bool) error {
+	if !isChatMember(userID, chatID) {
+		return errNotChatMember
+	}
+
+	if typing {
+		if realtime.Typing.Start(chatID, userID) {
+			publishTyping(chatID, userID, realtime.TypingStart)
+		}
+		return nil
+	}
+
+	if realtime.Typing.Stop(chatID, userID) {
+		publishTyping(chatID, userID, realtime.TypingStop)
+	}
+	return nil
+}
+
+// clearTyping ends a typing state without a membership check, e.g. once the
+// user's message has been sent
+func clearTyping(chatID, userID uint) {
+	if realtime.Typing.Stop(chatID, userID) {
+		publishTyping(chatID, userID, realtime.TypingStop)
+	}
+}
+
+func publishTyping(chatID, userID uint, eventType string) {
+	data := map[string]interface{}{
+		"user_id": userID,
+	}
+	if eventType == realtime.TypingStart {
+		data["expires_in_ms"] = realtime.TypingTTL.Milliseconds()
+	}
+	realtime.Publish(realtime.Event{
+		Type:       eventType,
+		ChatID:     chatID,
+		Data:       data,
+		SkipUserID: userID,
+	})
+}
+
+// handleClientAction processes WebSocket actions beyond subscriptions
+func handleClientAction(userID uint, msg realtime.ClientMessage) error {
+	switch msg.Action {
+	case realtime.ActionTypingStart:
+		return setTyping(userID, msg.ChatID, true)
+	case realtime.ActionTypingStop:
+		return setTyping(userID, msg.ChatID, false)
+	}
+	return errors.New("Unknown action")
+}
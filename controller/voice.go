@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"ChatApiServer/media"
+	"ChatApiServer/models"
+	"ChatApiServer/storage"
+	"errors"
+	"io"
+)
+
+// voiceUploadTypes are the sniffed content types probed for voice note
+// metadata on upload
+var voiceUploadTypes = map[string]bool{
+	"application/ogg": true,
+	"audio/wave":      true,
+}
+
+// probeVoice reads a stored recording's codec, duration and waveform. Audio
+// that cannot be used as a voice note returns zero values and stays a plain
+// file.
+func probeVoice(key string) (string, int64, []int) {
+	blob, err := storage.Default.Open(key)
+	if err != nil {
+		return "", 0, nil
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return "", 0, nil
+	}
+	info, err := media.ProbeAudio(data)
+	if err != nil {
+		return "", 0, nil
+	}
+	return info.Codec, info.DurationMs, info.Waveform
+}
+
+// validateVoiceMessage checks a voice message carries exactly one recording
+// in a supported format and no text
+func validateVoiceMessage(text string, attachments []models.Attachment) error {
+	if text != "" {
+		return errors.New("Voice messages cannot have text")
+	}
+	if len(attachments) != 1 {
+		return errors.New("Voice messages need exactly one audio attachment")
+	}
+	if attachments[0].Codec == "" {
+		return errors.New("Voice messages must be Ogg Opus or PCM WAV recordings")
+	}
+	return nil
+}
@@ -18,6 +18,107 @@ func InitDB() {
 		panic("Failed to connect to database")
 	}
 
-	DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.Reaction{}, &models.ChatMember{}, &models.MessageStatus{})
+	DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.Reaction{}, &models.ChatMember{}, &models.MessageStatus{}, &models.RealtimeEvent{}, &models.RealtimeSignal{}, &models.MessageRevision{}, &models.HiddenMessage{}, &models.Attachment{}, &models.AttachmentThumbnail{}, &models.Poll{}, &models.PollOption{}, &models.PollVote{}, &models.PinnedMessage{}, &models.StarredMessage{})
+	backfillRoles()
+	mergeDirectChats()
 	fmt.Println("Database connected and migrated!")
 }
+
+// backfillRoles maps member roles from before roles were enforced onto the
+// defined set: blank roles become "member" and each chat's creator becomes
+// its owner unless the chat already has one
+func backfillRoles() {
+	DB.Model(&models.ChatMember{}).
+		Where("role IS NULL OR role NOT IN ?", []string{models.RoleOwner, models.RoleAdmin, models.RoleModerator, models.RoleMember, models.RoleReadOnly}).
+		Update("role", models.RoleMember)
+
+	var chats []models.Chat
+	DB.Select("id", "created_by").
+		Where("id NOT IN (?)", DB.Model(&models.ChatMember{}).Select("chat_id").Where("role = ?", models.RoleOwner)).
+		Find(&chats)
+	for _, chat := range chats {
+		DB.Model(&models.ChatMember{}).
+			Where("chat_id = ? AND user_id = ?", chat.ID, chat.CreatedBy).
+			Update("role", models.RoleOwner)
+	}
+}
+
+// mergeDirectChats assigns DirectKey to existing two-person private chats and
+// folds duplicate chats for the same pair into the oldest one, moving their
+// messages and read state across. Private chats without exactly two members
+// are left unkeyed.
+func mergeDirectChats() {
+	var chats []models.Chat
+	if err := DB.Preload("Members").
+		Where("is_group = ? AND direct_key IS NULL", false).
+		Order("id ASC").
+		Find(&chats).Error; err != nil {
+		fmt.Println("Direct chat migration failed:", err)
+		return
+	}
+
+	for _, chat := range chats {
+		if len(chat.Members) != 2 || chat.Members[0].UserID == chat.Members[1].UserID {
+			continue
+		}
+		key := models.DirectChatKey(chat.Members[0].UserID, chat.Members[1].UserID)
+
+		var keep models.Chat
+		err := DB.Preload("Members").Where("direct_key = ?", key).First(&keep).Error
+		if err != nil {
+			DB.Model(&chat).Update("direct_key", key)
+			continue
+		}
+		if err := mergeChatInto(chat, keep); err != nil {
+			fmt.Printf("Failed to merge chat %d into %d: %v\n", chat.ID, keep.ID, err)
+		}
+	}
+}
+
+// mergeChatInto moves dup's messages and member state into keep, then
+// deletes dup. Both chats must have the same members.
+func mergeChatInto(dup, keep models.Chat) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, m := range dup.Members {
+			for _, k := range keep.Members {
+				if k.UserID != m.UserID {
+					continue
+				}
+				if err := tx.Model(&models.MessageStatus{}).
+					Where("chat_member_id = ?", m.ID).
+					Update("chat_member_id", k.ID).Error; err != nil {
+					return err
+				}
+				if m.LastReadMessageID != nil && (k.LastReadMessageID == nil || *m.LastReadMessageID > *k.LastReadMessageID) {
+					if err := tx.Model(&k).Updates(map[string]interface{}{
+						"last_read_message_id": m.LastReadMessageID,
+						"last_read_at":         m.LastReadAt,
+					}).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if err := tx.Model(&models.Message{}).Unscoped().
+			Where("chat_id = ?", dup.ID).
+			Update("chat_id", keep.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("chat_id = ?", dup.ID).Delete(&models.ChatMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&dup).Error; err != nil {
+			return err
+		}
+
+		var last models.Message
+		if err := tx.Where("chat_id = ?", keep.ID).Order("id DESC").First(&last).Error; err == nil {
+			return tx.Model(&keep).Updates(map[string]interface{}{
+				"last_message":    last.Text,
+				"last_updated_at": last.CreatedAt,
+			}).Error
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"ChatApiServer/models"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/chat.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.ChatMember{}, &models.MessageStatus{}); err != nil {
+		t.Fatal(err)
+	}
+	prev := DB
+	t.Cleanup(func() { DB = prev })
+	DB = db
+}
+
+// privateChat creates an unkeyed private chat between users 1 and 2 with one
+// message from user 1, which user 2 has read
+func privateChat(t *testing.T, text string, at time.Time) (models.Chat, models.Message) {
+	t.Helper()
+	chat := models.Chat{CreatedBy: 1, CreatedAt: at}
+	if err := DB.Create(&chat).Error; err != nil {
+		t.Fatal(err)
+	}
+	msg := models.Message{ChatID: chat.ID, SenderID: 1, Text: text, Type: "text", CreatedAt: at}
+	DB.Create(&msg)
+	members := []models.ChatMember{
+		{ChatID: chat.ID, UserID: 1},
+		{ChatID: chat.ID, UserID: 2, LastReadMessageID: &msg.ID, LastReadAt: &at},
+	}
+	DB.Create(&members)
+	DB.Create(&models.MessageStatus{MessageID: msg.ID, UserID: 2, ChatMemberID: members[1].ID, Status: "read"})
+	return chat, msg
+}
+
+func TestMergeDirectChats(t *testing.T) {
+	openTestDB(t)
+	now := time.Now()
+	keep, _ := privateChat(t, "first", now.Add(-time.Hour))
+	dup, newest := privateChat(t, "second", now)
+
+	// A group with the same two members is not a private chat
+	group := models.Chat{Name: "pair", IsGroup: true, CreatedBy: 1}
+	DB.Create(&group)
+	DB.Create(&[]models.ChatMember{{ChatID: group.ID, UserID: 1}, {ChatID: group.ID, UserID: 2}})
+
+	mergeDirectChats()
+	// Running it again finds nothing left to merge
+	mergeDirectChats()
+
+	var chats []models.Chat
+	DB.Where("is_group = ?", false).Find(&chats)
+	if len(chats) != 1 || chats[0].ID != keep.ID {
+		t.Fatalf("private chats after merge: %+v", chats)
+	}
+	if chats[0].DirectKey == nil || *chats[0].DirectKey != models.DirectChatKey(2, 1) {
+		t.Fatalf("direct key = %v", chats[0].DirectKey)
+	}
+	if chats[0].LastMessage == nil || *chats[0].LastMessage != "second" {
+		t.Fatalf("last message = %v, want the duplicate's", chats[0].LastMessage)
+	}
+
+	var moved int64
+	DB.Model(&models.Message{}).Where("chat_id = ?", keep.ID).Count(&moved)
+	if moved != 2 {
+		t.Fatalf("%d messages in the kept chat, want 2", moved)
+	}
+	if err := DB.First(&models.Chat{}, dup.ID).Error; err == nil {
+		t.Fatal("duplicate chat still exists")
+	}
+
+	// User 2's read state follows them to the kept chat
+	var reader models.ChatMember
+	DB.Where("chat_id = ? AND user_id = ?", keep.ID, 2).First(&reader)
+	if reader.LastReadMessageID == nil || *reader.LastReadMessageID != newest.ID {
+		t.Fatalf("read up to %v, want %d", reader.LastReadMessageID, newest.ID)
+	}
+	var orphaned int64
+	DB.Model(&models.MessageStatus{}).Where("chat_member_id <> ?", reader.ID).Count(&orphaned)
+	if orphaned != 0 {
+		t.Fatalf("%d statuses still point at the duplicate's member", orphaned)
+	}
+}
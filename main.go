@@ -3,8 +3,11 @@ package main
 import (
 	"ChatApiServer/controller"
 	"ChatApiServer/database"
+	"ChatApiServer/realtime"
+	"ChatApiServer/storage"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 )
@@ -13,6 +16,37 @@ func main() {
 	// Initialize database
 	database.InitDB()
 
+	// Real-time fan-out: in-process by default, or through a MySQL outbox
+	// when several instances run behind a load balancer (EVENT_BUS=mysql)
+	if os.Getenv("EVENT_BUS") == "mysql" {
+		bus, err := realtime.NewMySQLBus(database.DB)
+		if err != nil {
+			log.Fatalf("Failed to start MySQL event bus: %v", err)
+		}
+		realtime.Init(bus)
+		log.Println("Real-time events shared through MySQL outbox")
+	}
+
+	// Attachment storage on the local filesystem (UPLOAD_DIR, default ./uploads)
+	uploadDir := os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = "uploads"
+	}
+	store, err := storage.NewLocalStore(uploadDir)
+	if err != nil {
+		log.Fatalf("Failed to open upload directory: %v", err)
+	}
+	storage.Default = store
+
+	// Generate image thumbnails in the background
+	controller.StartThumbnailWorker(2)
+
+	// Delete uploads that were never sent with a message
+	controller.StartUploadSweeper()
+
+	// Track last-seen times for connected users
+	controller.StartPresence()
+
 	// Create router
 	router := mux.NewRouter()
 
@@ -20,21 +54,38 @@ func main() {
 	router.HandleFunc("/signup", controller.Signup).Methods("POST")
 	router.HandleFunc("/login", controller.Login).Methods("POST")
 
+	// Real-time streams. Browsers cannot set headers on WebSocket or
+	// EventSource requests, so only these accept ?access_token=
+	streamRouter := router.PathPrefix("/api").Subrouter()
+	streamRouter.Use(controller.StreamAuthMiddleware)
+	streamRouter.Use(controller.PresenceMiddleware)
+	streamRouter.HandleFunc("/ws", controller.ServeWS).Methods("GET")
+	streamRouter.HandleFunc("/events", controller.StreamEvents).Methods("GET")
+
 	// Protected routes (require JWT auth)
 	authRouter := router.PathPrefix("/api").Subrouter()
 	authRouter.Use(controller.AuthMiddleware)
+	authRouter.Use(controller.PresenceMiddleware)
 
 	// User-related
 	authRouter.HandleFunc("/users", controller.CreateUser).Methods("POST")
 	authRouter.HandleFunc("/user/chats", controller.GetUserChats).Methods("GET")
+	authRouter.HandleFunc("/user/privacy", controller.UpdatePrivacy).Methods("PUT")
+	authRouter.HandleFunc("/user/starred", controller.GetStarredMessages).Methods("GET")
+	authRouter.HandleFunc("/users/{id}/presence", controller.GetUserPresence).Methods("GET")
 
 	// Chat-related
 	authRouter.HandleFunc("/chats", controller.CreateChat).Methods("POST")
+	authRouter.HandleFunc("/dms/{user_id}", controller.GetOrCreateDirectChat).Methods("POST")
 	authRouter.HandleFunc("/chats/{id}", controller.GetChat).Methods("GET")
 	authRouter.HandleFunc("/chats/{id}", controller.UpdateChat).Methods("PUT")
 	authRouter.HandleFunc("/chats/{id}", controller.DeleteChat).Methods("DELETE")
 	authRouter.HandleFunc("/chats/{chat_id}/add-users", controller.AddUserToGroupChat).Methods("POST")
 	authRouter.HandleFunc("/chats/{chat_id}/remove-users", controller.RemoveUserFromGroupChat).Methods("DELETE")
+	authRouter.HandleFunc("/chats/{chat_id}/members/{user_id}/role", controller.UpdateMemberRole).Methods("PUT")
+	authRouter.HandleFunc("/chats/{chat_id}/leave", controller.LeaveChat).Methods("POST")
+	authRouter.HandleFunc("/chats/{chat_id}/transfer-ownership", controller.TransferOwnership).Methods("POST")
+	authRouter.HandleFunc("/chats/{chat_id}/pins", controller.GetPinnedMessages).Methods("GET")
 
 	// Message-related
 	authRouter.HandleFunc("/messages", controller.SendMessage).Methods("POST")
@@ -43,17 +94,41 @@ func main() {
 	authRouter.HandleFunc("/messages/{id}", controller.DeleteMessage).Methods("DELETE")
 	authRouter.HandleFunc("/messages/{id}/delivered", controller.MarkDelivered).Methods("PUT")
 	authRouter.HandleFunc("/messages/{id}/read", controller.MarkRead).Methods("PUT")
+	authRouter.HandleFunc("/messages/{id}/played", controller.MarkPlayed).Methods("PUT")
+	authRouter.HandleFunc("/messages/{id}/votes", controller.CastVote).Methods("POST")
+	authRouter.HandleFunc("/messages/{id}/votes", controller.GetPollResults).Methods("GET")
+	authRouter.HandleFunc("/messages/{id}/forward", controller.ForwardMessage).Methods("POST")
+	authRouter.HandleFunc("/messages/{id}/pin", controller.PinMessage).Methods("POST")
+	authRouter.HandleFunc("/messages/{id}/pin", controller.UnpinMessage).Methods("DELETE")
+	authRouter.HandleFunc("/messages/{id}/star", controller.StarMessage).Methods("POST")
+	authRouter.HandleFunc("/messages/{id}/star", controller.UnstarMessage).Methods("DELETE")
+	authRouter.HandleFunc("/messages/{id}/thread", controller.GetThread).Methods("GET")
+	authRouter.HandleFunc("/messages/{id}/history", controller.GetMessageHistory).Methods("GET")
 	authRouter.HandleFunc("/messages/private/{chat_id}", controller.GetMessagesBetweenUsers).Methods("GET")
 	authRouter.HandleFunc("/chats/{chat_id}/messages", controller.GetMessagesInChat).Methods("GET")
+	authRouter.HandleFunc("/chats/{chat_id}/read", controller.MarkChatRead).Methods("PUT")
 	authRouter.HandleFunc("/chats/{chat_id}/messages/bulk", controller.SendMultipleMessages).Methods("POST")
 	authRouter.HandleFunc("/chats/{chat_id}/messages/search", controller.SearchMessagesInChat).Methods("POST")
 
+	// Real-time
+	authRouter.HandleFunc("/chats/{chat_id}/typing", controller.SetTyping).Methods("POST")
+	authRouter.HandleFunc("/chats/{chat_id}/presence", controller.GetChatPresence).Methods("GET")
+
+	// Attachments
+	authRouter.HandleFunc("/chats/{chat_id}/attachments", controller.UploadAttachment).Methods("POST")
+	authRouter.HandleFunc("/attachments/{id}", controller.DownloadAttachment).Methods("GET")
+	authRouter.HandleFunc("/attachments/{id}/thumbnails/{size}", controller.DownloadThumbnail).Methods("GET")
+
 	// Reactions
 	authRouter.HandleFunc("/messages/{message_id}/reactions", controller.AddOrUpdateReaction).Methods("POST")
 	authRouter.HandleFunc("/messages/{message_id}/reactions", controller.RemoveReaction).Methods("DELETE")
 	authRouter.HandleFunc("/messages/{message_id}/reactions", controller.GetReactions).Methods("GET")
 
-	// Server start
-	log.Println("✅ Server running at :8080")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	// Server start (PORT lets several instances run side by side locally)
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	log.Printf("✅ Server running at :%s", port)
+	log.Fatal(http.ListenAndServe(":"+port, router))
 }
@@ -0,0 +1,201 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// WaveformBars is the number of bars in a computed waveform
+const WaveformBars = 64
+
+// AudioInfo describes a recording usable as a voice note
+type AudioInfo struct {
+	Codec      string // "opus" or "pcm"
+	DurationMs int64
+	Waveform   []int // WaveformBars levels from 0 to 100
+}
+
+// ErrUnsupportedAudio is returned for audio that is not Ogg Opus or PCM WAV
+var ErrUnsupportedAudio = errors.New("audio must be Ogg Opus or PCM WAV")
+
+// ProbeAudio validates the container and codec of a recording and extracts
+// its duration and waveform
+func ProbeAudio(data []byte) (*AudioInfo, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return probeOggOpus(data)
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return probeWAV(data)
+	}
+	return nil, ErrUnsupportedAudio
+}
+
+// probeOggOpus walks the Ogg pages of the first logical stream. The duration
+// comes from the last granule position; without decoding, the waveform is
+// approximated from packet sizes, which track loudness in VBR Opus.
+func probeOggOpus(data []byte) (*AudioInfo, error) {
+	var (
+		serial    uint32
+		granule   int64
+		preSkip   int64
+		packets   []int
+		packetLen int
+		seen      int
+	)
+	for pos := 0; pos+27 <= len(data); {
+		if !bytes.Equal(data[pos:pos+4], []byte("OggS")) {
+			return nil, ErrUnsupportedAudio
+		}
+		pageSerial := binary.LittleEndian.Uint32(data[pos+14:])
+		segments := int(data[pos+26])
+		body := pos + 27 + segments
+		if body > len(data) {
+			break
+		}
+		if seen == 0 {
+			serial = pageSerial
+		}
+
+		offset := body
+		for _, lacing := range data[pos+27 : body] {
+			end := min(offset+int(lacing), len(data))
+			if pageSerial == serial {
+				if seen == 0 && packetLen == 0 {
+					if end-offset < 19 || !bytes.HasPrefix(data[offset:end], []byte("OpusHead")) {
+						return nil, ErrUnsupportedAudio
+					}
+					preSkip = int64(binary.LittleEndian.Uint16(data[offset+10:]))
+				}
+				packetLen += end - offset
+				if lacing < 255 {
+					// Skip the OpusHead and OpusTags header packets
+					if seen >= 2 {
+						packets = append(packets, packetLen)
+					}
+					seen++
+					packetLen = 0
+				}
+			}
+			offset = end
+		}
+		if pageSerial == serial {
+			if g := int64(binary.LittleEndian.Uint64(data[pos+6:])); g > 0 {
+				granule = g
+			}
+		}
+		pos = offset
+	}
+	if seen == 0 {
+		return nil, ErrUnsupportedAudio
+	}
+
+	// Opus granule positions always count 48 kHz samples
+	durationMs := max(0, (granule-preSkip)*1000/48000)
+	level := func(i int) float64 { return float64(packets[i]) }
+	return &AudioInfo{Codec: "opus", DurationMs: durationMs, Waveform: waveform(len(packets), level)}, nil
+}
+
+// probeWAV reads the fmt and data chunks of an integer PCM WAV file and
+// takes the peak amplitude of each waveform bar
+func probeWAV(data []byte) (*AudioInfo, error) {
+	var (
+		format, channels, bits uint16
+		byteRate               uint32
+		samples                []byte
+		haveFmt                bool
+	)
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		start := pos + 8
+		end := start + size
+		if size < 0 || end > len(data) {
+			// Streaming writers leave the size unset; take what is there
+			end = len(data)
+		}
+		switch id {
+		case "fmt ":
+			if end-start < 16 {
+				return nil, ErrUnsupportedAudio
+			}
+			format = binary.LittleEndian.Uint16(data[start:])
+			channels = binary.LittleEndian.Uint16(data[start+2:])
+			byteRate = binary.LittleEndian.Uint32(data[start+8:])
+			bits = binary.LittleEndian.Uint16(data[start+14:])
+			if format == 0xFFFE && end-start >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE keeps the real format in its sub-format GUID
+				format = binary.LittleEndian.Uint16(data[start+24:])
+			}
+			haveFmt = true
+		case "data":
+			samples = data[start:end]
+		}
+		// Chunks are padded to an even length
+		pos = end + size%2
+	}
+
+	if !haveFmt || format != 1 || channels == 0 || byteRate == 0 || samples == nil {
+		return nil, ErrUnsupportedAudio
+	}
+	width := int(bits+7) / 8
+	if width < 1 || width > 4 {
+		return nil, ErrUnsupportedAudio
+	}
+
+	frame := width * int(channels)
+	level := func(i int) float64 {
+		peak := 0.0
+		for c := 0; c < int(channels); c++ {
+			peak = math.Max(peak, math.Abs(pcmSample(samples[i*frame+c*width:], width)))
+		}
+		return peak
+	}
+
+	return &AudioInfo{
+		Codec:      "pcm",
+		DurationMs: int64(len(samples)) * 1000 / int64(byteRate),
+		Waveform:   waveform(len(samples)/frame, level),
+	}, nil
+}
+
+// pcmSample decodes one little-endian sample scaled to [-1, 1]. 8-bit WAV
+// samples are unsigned; wider ones are signed.
+func pcmSample(b []byte, width int) float64 {
+	if width == 1 {
+		return (float64(b[0]) - 128) / 128
+	}
+	var v int32
+	for i := 0; i < width; i++ {
+		v |= int32(b[i]) << (8 * (4 - width + i))
+	}
+	return float64(v) / math.MaxInt32
+}
+
+// waveform reduces the n levels returned by level to WaveformBars peaks
+// scaled so the loudest bar is 100. Levels are read one at a time, so long
+// recordings need no more memory than the bars.
+func waveform(n int, level func(i int) float64) []int {
+	bars := make([]int, 0, WaveformBars)
+	if n == 0 {
+		return bars
+	}
+
+	peaks := make([]float64, min(WaveformBars, n))
+	loudest := 0.0
+	for i := 0; i < n; i++ {
+		l := level(i)
+		bar := int(int64(i) * int64(len(peaks)) / int64(n))
+		peaks[bar] = math.Max(peaks[bar], l)
+		loudest = math.Max(loudest, l)
+	}
+	for _, p := range peaks {
+		if loudest == 0 {
+			bars = append(bars, 0)
+		} else {
+			bars = append(bars, int(math.Round(p/loudest*100)))
+		}
+	}
+	return bars
+}
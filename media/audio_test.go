@@ -0,0 +1,225 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// wavFile builds a WAV file from a fmt chunk body and sample data.
+// dataSize overrides the data chunk's declared size when not -1.
+func wavFile(fmtChunk, samples []byte, dataSize int64) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.WriteString("WAVE")
+	if fmtChunk != nil {
+		b.WriteString("fmt ")
+		binary.Write(&b, binary.LittleEndian, uint32(len(fmtChunk)))
+		b.Write(fmtChunk)
+	}
+	if samples != nil {
+		if dataSize == -1 {
+			dataSize = int64(len(samples))
+		}
+		b.WriteString("data")
+		binary.Write(&b, binary.LittleEndian, uint32(dataSize))
+		b.Write(samples)
+	}
+	return b.Bytes()
+}
+
+// pcmFormat is a fmt chunk for integer PCM
+func pcmFormat(format, channels uint16, rate uint32, bits uint16) []byte {
+	var b bytes.Buffer
+	width := uint32(bits+7) / 8
+	binary.Write(&b, binary.LittleEndian, format)
+	binary.Write(&b, binary.LittleEndian, channels)
+	binary.Write(&b, binary.LittleEndian, rate)
+	binary.Write(&b, binary.LittleEndian, rate*uint32(channels)*width)
+	binary.Write(&b, binary.LittleEndian, uint16(channels)*uint16(width))
+	binary.Write(&b, binary.LittleEndian, bits)
+	return b.Bytes()
+}
+
+// extensibleFormat is a WAVE_FORMAT_EXTENSIBLE fmt chunk for subFormat
+func extensibleFormat(subFormat uint16) []byte {
+	b := bytes.NewBuffer(pcmFormat(0xFFFE, 1, 8000, 16))
+	binary.Write(b, binary.LittleEndian, uint16(22)) // extension size
+	binary.Write(b, binary.LittleEndian, uint16(16)) // valid bits
+	binary.Write(b, binary.LittleEndian, uint32(4))  // channel mask
+	binary.Write(b, binary.LittleEndian, subFormat)
+	b.Write(make([]byte, 14)) // rest of the GUID
+	return b.Bytes()
+}
+
+// ramp16 is n 16-bit mono samples rising from silence to full scale
+func ramp16(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		binary.Write(&b, binary.LittleEndian, int16(i*32767/(n-1)))
+	}
+	return b.Bytes()
+}
+
+// oggPage builds one Ogg page of packets in the stream serial
+func oggPage(serial uint32, granule int64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+	var b bytes.Buffer
+	b.WriteString("OggS")
+	b.Write([]byte{0, 0})
+	binary.Write(&b, binary.LittleEndian, granule)
+	binary.Write(&b, binary.LittleEndian, serial)
+	b.Write(make([]byte, 8)) // sequence number and CRC, not checked
+	b.WriteByte(byte(len(lacing)))
+	b.Write(lacing)
+	b.Write(body)
+	return b.Bytes()
+}
+
+// opusHead is an OpusHead packet with the given pre-skip
+func opusHead(preSkip uint16) []byte {
+	var b bytes.Buffer
+	b.WriteString("OpusHead")
+	b.Write([]byte{1, 1})
+	binary.Write(&b, binary.LittleEndian, preSkip)
+	binary.Write(&b, binary.LittleEndian, uint32(48000))
+	b.Write([]byte{0, 0, 0})
+	return b.Bytes()
+}
+
+// opusFile is a one-second Ogg Opus stream of packets whose sizes grow, with
+// a page from another stream in the middle
+func opusFile() []byte {
+	var packets [][]byte
+	for i := 0; i < 50; i++ {
+		packets = append(packets, make([]byte, 10+i*6))
+	}
+	var b bytes.Buffer
+	b.Write(oggPage(7, 0, opusHead(312)))
+	b.Write(oggPage(7, 0, []byte("OpusTags")))
+	b.Write(oggPage(7, 24000, packets[:25]...))
+	b.Write(oggPage(9, 99999999, make([]byte, 300)))
+	b.Write(oggPage(7, 48000+312, packets[25:]...))
+	return b.Bytes()
+}
+
+func TestProbeAudio(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		codec      string
+		durationMs int64
+		bars       int
+	}{
+		{"wav 16-bit mono", wavFile(pcmFormat(1, 1, 8000, 16), ramp16(8000), -1), "pcm", 1000, WaveformBars},
+		{"wav 8-bit stereo", wavFile(pcmFormat(1, 2, 4000, 8), bytes.Repeat([]byte{128, 255}, 2000), -1), "pcm", 500, WaveformBars},
+		{"wav extensible", wavFile(extensibleFormat(1), ramp16(4000), -1), "pcm", 500, WaveformBars},
+		{"wav streamed without a data size", wavFile(pcmFormat(1, 1, 8000, 16), ramp16(800), 0xFFFFFFFF), "pcm", 100, WaveformBars},
+		{"wav shorter than the bars", wavFile(pcmFormat(1, 1, 8000, 16), ramp16(10), -1), "pcm", 1, 10},
+		{"wav with an odd final sample byte", wavFile(pcmFormat(1, 1, 8000, 16), append(ramp16(80), 1), -1), "pcm", 10, WaveformBars},
+		{"ogg opus", opusFile(), "opus", 1000, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ProbeAudio(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Codec != tt.codec || info.DurationMs != tt.durationMs || len(info.Waveform) != tt.bars {
+				t.Fatalf("got %s %dms with %d bars, want %s %dms with %d bars",
+					info.Codec, info.DurationMs, len(info.Waveform), tt.codec, tt.durationMs, tt.bars)
+			}
+			loudest := 0
+			for _, level := range info.Waveform {
+				if level < 0 || level > 100 {
+					t.Fatalf("level %d out of range in %v", level, info.Waveform)
+				}
+				loudest = max(loudest, level)
+			}
+			if loudest != 100 {
+				t.Fatalf("waveform %v not scaled to 100", info.Waveform)
+			}
+		})
+	}
+}
+
+func TestProbeAudioWaveformFollowsLoudness(t *testing.T) {
+	info, err := ProbeAudio(wavFile(pcmFormat(1, 1, 8000, 16), ramp16(6400), -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(info.Waveform); i++ {
+		if info.Waveform[i] < info.Waveform[i-1] {
+			t.Fatalf("rising ramp gave a falling waveform: %v", info.Waveform)
+		}
+	}
+
+	silent, err := ProbeAudio(wavFile(pcmFormat(1, 1, 8000, 16), make([]byte, 1600), -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range silent.Waveform {
+		if level != 0 {
+			t.Fatalf("silence gave waveform %v", silent.Waveform)
+		}
+	}
+}
+
+func TestProbeAudioRejects(t *testing.T) {
+	samples := ramp16(100)
+	vorbis := append([]byte{1}, []byte("vorbis0123456789012345")...)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"mp3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00")},
+		{"truncated RIFF header", []byte("RIFF\x00\x00\x00\x00WAV")},
+		{"wav without chunks", wavFile(nil, nil, -1)},
+		{"wav without fmt", wavFile(nil, samples, -1)},
+		{"wav without data", wavFile(pcmFormat(1, 1, 8000, 16), nil, -1)},
+		{"wav with a short fmt", wavFile(pcmFormat(1, 1, 8000, 16)[:12], samples, -1)},
+		{"wav float samples", wavFile(pcmFormat(3, 1, 8000, 32), samples, -1)},
+		{"wav extensible float", wavFile(extensibleFormat(3), samples, -1)},
+		{"wav without channels", wavFile(pcmFormat(1, 0, 8000, 16), samples, -1)},
+		{"wav with 40-bit samples", wavFile(pcmFormat(1, 1, 8000, 40), samples, -1)},
+		{"truncated ogg page header", []byte("OggS\x00\x00\x00\x00")},
+		{"ogg vorbis", oggPage(1, 0, vorbis)},
+		{"ogg with a short OpusHead", oggPage(1, 0, opusHead(0)[:12])},
+		{"ogg with a corrupt second page", append(oggPage(1, 0, opusHead(0)), []byte("OggX0000000000000000000000000000")...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if info, err := ProbeAudio(tt.data); !errors.Is(err, ErrUnsupportedAudio) {
+				t.Fatalf("got %+v, %v; want ErrUnsupportedAudio", info, err)
+			}
+		})
+	}
+}
+
+func TestProbeAudioTruncated(t *testing.T) {
+	// Cut anywhere, a recording either still probes or is rejected; it never
+	// reads past the end
+	files := map[string][]byte{
+		"wav": wavFile(pcmFormat(1, 2, 8000, 24), bytes.Repeat([]byte{1, 2, 3}, 400), -1),
+		"ogg": opusFile(),
+	}
+	for name, data := range files {
+		for n := 0; n < len(data); n++ {
+			info, err := ProbeAudio(data[:n])
+			if err == nil && len(info.Waveform) > WaveformBars {
+				t.Fatalf("%s cut to %d bytes: %d bars", name, n, len(info.Waveform))
+			}
+		}
+	}
+}
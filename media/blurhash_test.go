@@ -0,0 +1,87 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func solid(w, h int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestBlurhash(t *testing.T) {
+	gradient := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			gradient.Set(x, y, color.RGBA{uint8(x), uint8(y * 2), 64, 255})
+		}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+		x, y int
+		size string // the components flag
+		dc   string // average colour, "" to skip
+	}{
+		// The DC of a flat image is the colour itself
+		{"solid black", solid(32, 32, color.Black), 4, 3, "L", "0000"},
+		{"solid white", solid(32, 32, color.White), 4, 3, "L", "TSUA"},
+		{"one component", solid(10, 10, color.White), 1, 1, "0", "TSUA"},
+		{"gradient", gradient, 4, 3, "L", ""},
+		{"most detail", gradient, 9, 9, "|", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := Blurhash(tt.img, tt.x, tt.y)
+			if want := 4 + 2*tt.x*tt.y; len(hash) != want {
+				t.Fatalf("hash %q has length %d, want %d", hash, len(hash), want)
+			}
+			if hash[:1] != tt.size {
+				t.Fatalf("hash %q has components flag %q, want %q", hash, hash[:1], tt.size)
+			}
+			if tt.dc != "" && hash[2:6] != tt.dc {
+				t.Fatalf("hash %q has DC %q, want %q", hash, hash[2:6], tt.dc)
+			}
+			for _, c := range hash {
+				if !strings.ContainsRune(base83Chars, c) {
+					t.Fatalf("hash %q has non-base83 character %q", hash, c)
+				}
+			}
+			if again := Blurhash(tt.img, tt.x, tt.y); again != hash {
+				t.Fatalf("not deterministic: %q then %q", hash, again)
+			}
+		})
+	}
+}
+
+func TestBlurhashEmptyImage(t *testing.T) {
+	if hash := Blurhash(image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3); hash != "" {
+		t.Fatalf("empty image hashed to %q", hash)
+	}
+}
+
+func TestEncode83(t *testing.T) {
+	tests := []struct {
+		value, length int
+		want          string
+	}{
+		{0, 1, "0"},
+		{82, 1, "~"},
+		{83, 2, "10"},
+		{0xFFFFFF, 4, "TSUA"},
+	}
+	for _, tt := range tests {
+		if got := encode83(tt.value, tt.length); got != tt.want {
+			t.Errorf("encode83(%d, %d) = %q, want %q", tt.value, tt.length, got, tt.want)
+		}
+	}
+}
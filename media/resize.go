@@ -0,0 +1,61 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	// Register the formats accepted for upload with image.Decode
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxPixels bounds the images that will be decoded, so a small file that
+// declares huge dimensions cannot exhaust memory
+const MaxPixels = 50_000_000
+
+// Fit scales img down so its longer side is at most maxSide, keeping the
+// aspect ratio. Images already small enough are returned unchanged.
+func Fit(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		return img
+	}
+	if w >= h {
+		w, h = maxSide, max(1, h*maxSide/w)
+	} else {
+		w, h = max(1, w*maxSide/h), maxSide
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img as JPEG, or as PNG when it has transparent pixels,
+// returning the bytes and their content type
+func Encode(img image.Image) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if opaque(img) {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// opaque reports whether every pixel of img is fully opaque
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
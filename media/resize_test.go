@@ -0,0 +1,112 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name          string
+		w, h, maxSide int
+		wantW, wantH  int
+	}{
+		{"landscape", 1000, 500, 100, 100, 50},
+		{"portrait", 300, 1200, 400, 100, 400},
+		{"square", 640, 640, 320, 320, 320},
+		{"already small", 80, 60, 96, 80, 60},
+		{"exactly the limit", 96, 50, 96, 96, 50},
+		{"extreme aspect keeps a pixel", 5000, 2, 100, 100, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, tt.w, tt.h))
+			got := Fit(img, tt.maxSide).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Fatalf("got %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestFitKeepsOffsetImages(t *testing.T) {
+	// Sub-images do not start at the origin
+	src := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			src.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	sub := src.SubImage(image.Rect(100, 100, 300, 200))
+	got := Fit(sub, 50)
+	if b := got.Bounds(); b.Dx() != 50 || b.Dy() != 25 {
+		t.Fatalf("got %v", b)
+	}
+	if r, _, _, _ := got.At(25, 12).RGBA(); r>>8 != 255 {
+		t.Fatalf("scaled pixel lost its colour: r=%d", r>>8)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	opaqueImg := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range opaqueImg.Pix {
+		opaqueImg.Pix[i] = 255
+	}
+	tests := []struct {
+		name     string
+		img      image.Image
+		mimeType string
+		decode   func(*bytes.Reader) (image.Image, error)
+	}{
+		{"opaque as JPEG", opaqueImg, "image/jpeg", func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) }},
+		{"transparent as PNG", image.NewRGBA(image.Rect(0, 0, 8, 8)), "image/png", func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) }},
+		{"no alpha channel as JPEG", image.NewGray(image.Rect(0, 0, 8, 8)), "image/jpeg", func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, mimeType, err := Encode(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mimeType != tt.mimeType {
+				t.Fatalf("encoded as %s, want %s", mimeType, tt.mimeType)
+			}
+			img, err := tt.decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("output does not decode: %v", err)
+			}
+			if img.Bounds().Dx() != 8 || img.Bounds().Dy() != 8 {
+				t.Fatalf("decoded as %v", img.Bounds())
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsBrokenImages(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64)))
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated header", valid[:12]},
+		{"truncated data", valid[:len(valid)-20]},
+		{"malformed header", append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 32)...)},
+		{"not an image", []byte("GIF89a but not really")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The formats registered here must fail cleanly, not panic
+			if _, _, err := image.Decode(bytes.NewReader(tt.data)); err == nil {
+				t.Fatal("decoded a broken image")
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -15,8 +16,21 @@ type User struct {
 	Phone     string         `json:"phone"`
 	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Presence; only exposed through the presence endpoints, which apply
+	// the user's privacy setting
+	LastSeenAt         *time.Time `json:"-"`
+	OnlineUntil        *time.Time `json:"-"`                                 // pushed forward while the user is active, then left to run out
+	LastSeenVisibility string     `gorm:"size:16;default:everyone" json:"-"` // "everyone", "contacts" or "nobody"
 }
 
+// Last-seen visibility settings
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityContacts = "contacts"
+	VisibilityNobody   = "nobody"
+)
+
 // Chat represents a conversation (group or one-on-one)
 type Chat struct {
 	ID            uint           `gorm:"primaryKey" json:"id"`
@@ -30,6 +44,18 @@ type Chat struct {
 	Members       []ChatMember   `json:"members" gorm:"foreignKey:ChatID"`
 	Messages      []Message      `json:"messages,omitempty" gorm:"foreignKey:ChatID"`
 	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// DirectKey identifies the pair of users in a private chat (see
+	// DirectChatKey) so there is only ever one chat per pair; nil for groups
+	DirectKey *string `gorm:"size:64;uniqueIndex" json:"-"`
+}
+
+// DirectChatKey returns the DirectKey for a private chat between a and b
+func DirectChatKey(a, b uint) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
 }
 
 // ChatMember links users to chats with additional metadata
@@ -38,37 +64,256 @@ type ChatMember struct {
 	ChatID   uint      `json:"chat_id"`
 	UserID   uint      `json:"user_id"`
 	AddedBy  *uint     `json:"added_by,omitempty"`
-	Role     string    `json:"role,omitempty"` // e.g. "admin", "member"
+	Role     string    `gorm:"size:16;default:member" json:"role,omitempty"` // one of the Role* constants
 	JoinedAt time.Time `gorm:"autoCreateTime" json:"joined_at"`
+	// Read-up-to watermark: everything at or below this message ID is read
+	LastReadMessageID *uint      `json:"last_read_message_id,omitempty"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"`
 	// Chat field removed for clarity unless specifically required
 }
 
+// Chat member roles, from most to least privileged
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+	RoleReadOnly  = "read_only"
+)
+
 // Message represents a message sent in a chat
 type Message struct {
 	ID          uint            `gorm:"primaryKey" json:"id"`
 	ChatID      uint            `gorm:"index" json:"chat_id"`
 	SenderID    uint            `gorm:"index" json:"sender_id"`
 	Text        string          `json:"text"`
-	Type        string          `json:"type"` // e.g., "text", "image"
+	Type        string          `json:"type"` // e.g., "text", "image", "system"
 	CreatedAt   time.Time       `gorm:"autoCreateTime" json:"created_at"`
 	ReplyToID   *uint           `gorm:"index" json:"reply_to_id,omitempty"`
 	ReplyTo     *Message        `gorm:"foreignKey:ReplyToID" json:"-"`
 	Reactions   []Reaction      `gorm:"foreignKey:MessageID" json:"reactions"`
 	StatusTrack []MessageStatus `gorm:"foreignKey:MessageID" json:"status_track"`
 	Sender      *User           `json:"sender,omitempty"`
-	DeletedAt   gorm.DeletedAt  `gorm:"index" json:"-"`
-	UpdatedAt   time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+	Attachments []Attachment    `gorm:"foreignKey:MessageID" json:"attachments,omitempty"`
+	System      *SystemEvent    `gorm:"type:text;serializer:json" json:"system,omitempty"` // set on system messages only
+	Poll        *Poll           `gorm:"foreignKey:MessageID" json:"poll,omitempty"`        // set on poll messages only
+	// Threads: replies point at their parent through ReplyToID
+	ReplyCount   int             `gorm:"default:0" json:"reply_count"`
+	LastReplyAt  *time.Time      `json:"last_reply_at,omitempty"`
+	ReplyPreview *MessagePreview `gorm:"-" json:"reply_to,omitempty"` // quoted parent, filled in by handlers
+	EditedAt     *time.Time      `json:"edited_at,omitempty"`
+	EditCount    int             `gorm:"default:0" json:"edit_count"`
+	// Set on copies made by forwarding; the sender is the original author,
+	// kept through repeated forwards
+	ForwardedFromMessageID *uint `gorm:"index" json:"forwarded_from_message_id,omitempty"`
+	ForwardedFromSenderID  *uint `json:"forwarded_from_sender_id,omitempty"`
+	// Set when the message was deleted for everyone; the row stays as a
+	// tombstone with its content cleared
+	DeletedForEveryoneAt *time.Time     `json:"deleted_for_everyone_at,omitempty"`
+	DeletedBy            *uint          `json:"deleted_by,omitempty"`
+	DeletedAt            gorm.DeletedAt `gorm:"index" json:"-"`
+	UpdatedAt            time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Attachment is an uploaded file. It belongs to the chat it was uploaded to
+// and is linked to a message once sent; the bytes live in a storage.BlobStore
+// under StorageKey.
+type Attachment struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	MessageID  *uint     `gorm:"index" json:"message_id,omitempty"`
+	ChatID     uint      `gorm:"index" json:"chat_id"`
+	UploaderID uint      `gorm:"index" json:"uploader_id"`
+	FileName   string    `json:"file_name"`
+	MimeType   string    `gorm:"size:128" json:"mime_type"`
+	Size       int64     `json:"size"`
+	Checksum   string    `gorm:"size:64" json:"checksum"` // hex SHA-256 of the contents
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	StorageKey string    `gorm:"size:255" json:"-"`
+	URL        string    `gorm:"-" json:"url"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	// Previews generated in the background for images
+	ThumbnailStatus    string                `gorm:"size:16" json:"thumbnail_status,omitempty"`
+	ThumbnailClaimedAt *time.Time            `json:"-"` // when a worker last claimed it
+	Blurhash           string                `gorm:"size:64" json:"blurhash,omitempty"`
+	Thumbnails         []AttachmentThumbnail `gorm:"foreignKey:AttachmentID" json:"thumbnails,omitempty"`
+	// Set for recordings usable as voice notes (Ogg Opus or PCM WAV)
+	Codec      string `gorm:"size:16" json:"codec,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Waveform   []int  `gorm:"type:text;serializer:json" json:"waveform,omitempty"` // levels 0-100
+}
+
+// Thumbnail generation states of an image attachment
+const (
+	ThumbnailPending    = "pending"
+	ThumbnailProcessing = "processing" // claimed by a worker
+	ThumbnailReady      = "ready"
+	ThumbnailFailed     = "failed"
+)
+
+// AfterFind sets the download URL, which requires the usual authentication
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	return nil
+}
+
+// AfterCreate sets the download URL on new attachments
+func (a *Attachment) AfterCreate(tx *gorm.DB) error {
+	return a.AfterFind(tx)
+}
+
+// AttachmentThumbnail is a scaled-down copy of an image attachment, stored
+// next to the original
+type AttachmentThumbnail struct {
+	ID           uint   `gorm:"primaryKey" json:"-"`
+	AttachmentID uint   `gorm:"uniqueIndex:idx_thumbnail_size" json:"-"`
+	Size         string `gorm:"size:16;uniqueIndex:idx_thumbnail_size" json:"size"` // small, medium or large
+	MimeType     string `gorm:"size:32" json:"mime_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Bytes        int64  `json:"bytes"`
+	StorageKey   string `gorm:"size:255" json:"-"`
+	URL          string `gorm:"-" json:"url"`
+}
+
+// AfterFind sets the thumbnail's download URL
+func (t *AttachmentThumbnail) AfterFind(tx *gorm.DB) error {
+	t.URL = fmt.Sprintf("/api/attachments/%d/thumbnails/%s", t.AttachmentID, t.Size)
+	return nil
+}
+
+// AfterCreate sets the download URL on new thumbnails
+func (t *AttachmentThumbnail) AfterCreate(tx *gorm.DB) error {
+	return t.AfterFind(tx)
+}
+
+// Poll is the question and options of a poll message. The message text
+// holds the question too, for previews and search.
+type Poll struct {
+	ID             uint         `gorm:"primaryKey" json:"id"`
+	MessageID      uint         `gorm:"uniqueIndex" json:"message_id"`
+	Question       string       `json:"question"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	Anonymous      bool         `json:"anonymous"` // results never name voters
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	Options        []PollOption `gorm:"foreignKey:PollID" json:"options"`
+	TotalVoters    int          `gorm:"-" json:"total_voters"` // filled in by handlers
+	CreatedAt      time.Time    `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Closed reports whether the poll stopped accepting votes at now
+func (p *Poll) Closed(now time.Time) bool {
+	return p.ClosesAt != nil && !now.Before(*p.ClosesAt)
 }
 
+// PollOption is one answer of a poll
+type PollOption struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	PollID    uint   `gorm:"index" json:"-"`
+	Position  int    `json:"position"`
+	Text      string `json:"text"`
+	VoteCount int    `gorm:"-" json:"vote_count"` // filled in by handlers
+}
+
+// PollVote is one member's choice of an option
+type PollVote struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	PollID    uint      `gorm:"index" json:"-"`
+	OptionID  uint      `gorm:"uniqueIndex:idx_poll_vote_user" json:"option_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_poll_vote_user" json:"user_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"voted_at"`
+}
+
+// PinnedMessage marks a message pinned to the top of its chat
+type PinnedMessage struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	ChatID    uint      `gorm:"index" json:"chat_id"`
+	MessageID uint      `gorm:"uniqueIndex" json:"message_id"`
+	PinnedBy  uint      `json:"pinned_by"`
+	PinnedAt  time.Time `gorm:"autoCreateTime" json:"pinned_at"`
+}
+
+// StarredMessage is a message a user saved for later, with their own note
+// and tags. Stars are private to the user.
+type StarredMessage struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_starred_user_message" json:"-"`
+	MessageID uint      `gorm:"uniqueIndex:idx_starred_user_message" json:"message_id"`
+	ChatID    uint      `gorm:"index" json:"chat_id"`
+	Note      string    `gorm:"size:1000" json:"note,omitempty"`
+	Tags      []string  `gorm:"type:text;serializer:json" json:"tags,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"starred_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// MessageRevision keeps a previous version of an edited message
+type MessageRevision struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID uint      `gorm:"index" json:"message_id"`
+	Text      string    `json:"text"`
+	EditedBy  uint      `json:"edited_by"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"replaced_at"` // when this version was replaced
+}
+
+// HiddenMessage hides a message from one user's view of a chat
+// ("delete for me")
+type HiddenMessage struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID uint      `gorm:"uniqueIndex:idx_hidden_message_user" json:"message_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_hidden_message_user;index" json:"user_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// MessagePreview is a compact view of a message for chat lists and quotes
+type MessagePreview struct {
+	ID         uint      `json:"id"`
+	SenderID   uint      `json:"sender_id"`
+	SenderName string    `json:"sender_name,omitempty"`
+	Text       string    `json:"text"`
+	Type       string    `json:"type"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Message types with server-side rules
+const (
+	MessageTypeSystem = "system" // generated by the server for chat events
+	MessageTypeVoice  = "voice"  // a single recorded audio attachment
+	MessageTypePoll   = "poll"   // a question members vote on
+)
+
+// SystemEvent is the structured payload of a system message
+type SystemEvent struct {
+	Action    string `json:"action"` // one of the System* constants
+	ActorID   uint   `json:"actor_id"`
+	TargetIDs []uint `json:"target_ids,omitempty"`
+	OldValue  string `json:"old_value,omitempty"`
+	NewValue  string `json:"new_value,omitempty"`
+	MessageID uint   `json:"message_id,omitempty"` // the message an event refers to, e.g. when pinning
+}
+
+// System message actions
+const (
+	SystemChatCreated        = "chat_created"
+	SystemMembersAdded       = "members_added"
+	SystemMembersRemoved     = "members_removed"
+	SystemMemberLeft         = "member_left"
+	SystemChatRenamed        = "chat_renamed"
+	SystemDescriptionChanged = "description_changed"
+	SystemOwnerChanged       = "owner_changed"
+	SystemRoleChanged        = "role_changed"
+	SystemMessagePinned      = "message_pinned"
+)
+
 // MessageStatus tracks whether a message has been delivered/read per user
 type MessageStatus struct {
 	ID           uint       `gorm:"primaryKey" json:"id"`
 	MessageID    uint       `gorm:"index" json:"message_id"`
 	UserID       uint       `gorm:"index" json:"-"`
-	Status       string     `json:"status"` // e.g. "sent", "delivered", "read"
+	Status       string     `json:"status"` // e.g. "sent", "delivered", "read", "played"
 	SentAt       *time.Time `json:"sent_at,omitempty"`
 	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
 	ReadAt       *time.Time `json:"read_at,omitempty"`
+	PlayedAt     *time.Time `json:"played_at,omitempty"` // voice messages only
 	ChatMemberID uint       `gorm:"index" json:"chat_member_id"`
 }
 
@@ -79,3 +324,26 @@ type Reaction struct {
 	Emoji     string `json:"emoji"`
 	UserID    uint   `gorm:"index" json:"-"`
 }
+
+// RealtimeEvent is an outbox row used to share real-time events between
+// server instances
+type RealtimeEvent struct {
+	ID         uint64    `gorm:"primaryKey" json:"id"`
+	Type       string    `gorm:"size:64" json:"type"`
+	ChatID     uint      `json:"chat_id"`
+	Payload    []byte    `gorm:"type:longblob" json:"payload"`
+	SkipUserID uint      `json:"skip_user_id,omitempty"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// RealtimeSignal carries an ephemeral event (typing) between server
+// instances. Signals have no event ID, are never replayed and are pruned
+// within a minute.
+type RealtimeSignal struct {
+	ID         uint64    `gorm:"primaryKey" json:"id"`
+	Type       string    `gorm:"size:64" json:"type"`
+	ChatID     uint      `json:"chat_id"`
+	Payload    []byte    `gorm:"type:blob" json:"payload"`
+	SkipUserID uint      `json:"skip_user_id,omitempty"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
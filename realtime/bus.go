@@ -0,0 +1,112 @@
+package realtime
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// EventBus carries events from the instance that raised them to every
+// instance holding client connections
+type EventBus interface {
+	// Publish hands evt to the bus for delivery to all subscribers
+	Publish(evt Event) error
+
+	// Subscribe registers handler to receive every event published on the
+	// bus, including those raised by other instances
+	Subscribe(handler func(Event))
+
+	// Close stops delivery and releases resources
+	Close() error
+}
+
+// Replayer is implemented by buses that keep published events themselves, so
+// a reconnecting client can resume on any instance
+type Replayer interface {
+	// Replay returns the events for chatIDs with IDs in (afterID, upToID],
+	// oldest first. ok is false when some of them are no longer kept.
+	Replay(chatIDs []uint, afterID, upToID uint64) (events []Event, ok bool)
+}
+
+// Bus is the event bus used by Publish. It defaults to an in-process bus and
+// is replaced by Init.
+var Bus EventBus = newWiredInProcessBus()
+
+// Init switches Publish over to bus and wires it to the default hub
+func Init(bus EventBus) {
+	if r, ok := bus.(Replayer); ok {
+		Default.Replay = r.Replay
+	}
+	bus.Subscribe(Default.Broadcast)
+	Bus = bus
+}
+
+// Publish sends an event through the configured bus
+func Publish(evt Event) {
+	if err := Bus.Publish(evt); err != nil {
+		log.Printf("realtime: failed to publish %s event for chat %d: %v", evt.Type, evt.ChatID, err)
+	}
+}
+
+// SubscribeUser subscribes userID's live connections on every instance to
+// chatID, e.g. after the user has been added to the chat
+func SubscribeUser(userID, chatID uint) {
+	Publish(Event{Type: userSubscribed, ChatID: chatID, Data: subscription{UserID: userID}})
+}
+
+// UnsubscribeUser drops the subscriptions userID's connections on every
+// instance hold on chatID, e.g. after the user has been removed from the chat
+func UnsubscribeUser(userID, chatID uint) {
+	Publish(Event{Type: userUnsubscribed, ChatID: chatID, Data: subscription{UserID: userID}})
+}
+
+// InProcessBus delivers events synchronously to subscribers in this process.
+// It is enough for a single server instance.
+type InProcessBus struct {
+	mu       sync.Mutex
+	handlers []func(Event)
+	seq      uint64
+}
+
+// NewInProcessBus creates an in-process bus with no subscribers
+func NewInProcessBus() *InProcessBus {
+	return &InProcessBus{
+		// Seed IDs from the clock so they keep increasing across restarts and
+		// a stale Last-Event-ID is detected instead of silently matching
+		seq: uint64(time.Now().UnixMicro()),
+	}
+}
+
+func newWiredInProcessBus() *InProcessBus {
+	bus := NewInProcessBus()
+	bus.Subscribe(Default.Broadcast)
+	return bus
+}
+
+// Publish numbers evt and calls every subscriber with it. Publishing is
+// serialized so subscribers see IDs in increasing order; ephemeral events are
+// not numbered.
+func (b *InProcessBus) Publish(evt Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !evt.ephemeral() {
+		b.seq++
+		evt.ID = b.seq
+	}
+	for _, handler := range b.handlers {
+		handler(evt)
+	}
+	return nil
+}
+
+// Subscribe registers handler
+func (b *InProcessBus) Subscribe(handler func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Close is a no-op for the in-process bus
+func (b *InProcessBus) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package realtime
+
+import "time"
+
+// Event types pushed to connected chat members
+const (
+	MessageCreated  = "message.created"
+	MessageUpdated  = "message.updated"
+	MessageDeleted  = "message.deleted"
+	ReactionAdded   = "reaction.added"
+	ReactionUpdated = "reaction.updated"
+	ReactionRemoved = "reaction.removed"
+	ReceiptUpdated  = "receipt.updated"
+	ReadUpTo        = "receipt.read_up_to"
+	ThreadUpdated   = "thread.updated"
+
+	AttachmentProcessed = "attachment.processed"
+	PollUpdated         = "poll.updated"
+	MessagePinned       = "message.pinned"
+	MessageUnpinned     = "message.unpinned"
+
+	MemberRoleUpdated = "member.role_updated"
+)
+
+// Events that update subscriptions on every instance rather than reaching
+// clients
+const (
+	userSubscribed   = "user.subscribed"
+	userUnsubscribed = "user.unsubscribed"
+)
+
+// subscription is the payload of userSubscribed and userUnsubscribed
+type subscription struct {
+	UserID uint `json:"user_id"`
+}
+
+// Event is a real-time notification scoped to a single chat
+type Event struct {
+	ID        uint64      `json:"id,omitempty"`
+	Type      string      `json:"type"`
+	ChatID    uint        `json:"chat_id"`
+	Data      interface{} `json:"data,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+
+	// SkipUserID, when set, keeps the event from the user who caused it
+	SkipUserID uint `json:"-"`
+}
+
+// ephemeral events describe transient state and are not kept for replay
+func (evt Event) ephemeral() bool {
+	return evt.Type == TypingStart || evt.Type == TypingStop
+}
+
+// Reset tells a reconnecting client that events were missed and could not be
+// replayed, so it should refetch the chats it is displaying
+const Reset = "reset"
+
+// ClientMessage is a frame sent by a connected client, e.g.
+// {"action":"subscribe","chat_id":12}
+type ClientMessage struct {
+	Action string `json:"action"`
+	ChatID uint   `json:"chat_id,omitempty"`
+}
+
+// Client actions understood by the gateway
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+	ActionPing        = "ping"
+	ActionTypingStart = TypingStart
+	ActionTypingStop  = TypingStop
+)
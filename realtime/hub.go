@@ -0,0 +1,419 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Outbound frames buffered per connection before it is dropped as too slow
+	sendBufferSize = 64
+
+	// Recent events kept in memory per chat for Last-Event-ID replay
+	historySize = 256
+)
+
+// Hub tracks live connections and the chats each one is subscribed to
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+	byChat  map[uint]map[*Client]struct{}
+	byUser  map[uint]int          // live connection count per user
+	seq     uint64                // ID of the latest replayable event seen
+	first   uint64                // ID of the first one
+	history map[uint]*chatHistory // recent frames per chat, unless Replay is set
+
+	// Authorize reports whether a user may subscribe to a chat.
+	// When nil every subscription request is rejected.
+	Authorize func(userID, chatID uint) bool
+
+	// Replay, when set, loads events for chatIDs with IDs in
+	// (afterID, upToID] from the bus instead of the in-memory history. ok is
+	// false when some of them are no longer kept.
+	Replay func(chatIDs []uint, afterID, upToID uint64) (events []Event, ok bool)
+
+	// HandleAction processes client actions the hub does not handle itself,
+	// such as typing signals. A returned error is sent back to the client.
+	HandleAction func(userID uint, msg ClientMessage) error
+
+	// OnConnect and OnDisconnect are called when a user's first connection
+	// opens and when their last one closes
+	OnConnect    func(userID uint)
+	OnDisconnect func(userID uint)
+}
+
+// Client is a single live connection (WebSocket or SSE) owned by an
+// authenticated user
+type Client struct {
+	UserID uint
+
+	hub    *Hub
+	send   chan frame
+	subs   map[uint]struct{} // guarded by hub.mu
+	sendMu sync.Mutex        // guards send against close
+	closed bool
+}
+
+// frame is an encoded event ready to be written to a transport
+type frame struct {
+	id        uint64
+	chatID    uint
+	eventType string
+	body      []byte
+}
+
+// chatHistory is a chat's last historySize frames, oldest first
+type chatHistory struct {
+	frames  []frame
+	dropped uint64 // ID of the newest frame pushed out of frames
+}
+
+// Default is the hub used by the HTTP handlers
+var Default = NewHub()
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[*Client]struct{}),
+		byChat:  make(map[uint]map[*Client]struct{}),
+		byUser:  make(map[uint]int),
+		history: make(map[uint]*chatHistory),
+	}
+}
+
+// attach registers a new client subscribed to chatIDs. When replay is true,
+// buffered events for those chats newer than lastEventID are returned as
+// well; ok is false if some of them are no longer buffered.
+func (h *Hub) attach(userID uint, chatIDs []uint, lastEventID uint64, replay bool) (c *Client, missed []frame, ok bool) {
+	c = &Client{
+		UserID: userID,
+		hub:    h,
+		send:   make(chan frame, sendBufferSize),
+		subs:   make(map[uint]struct{}),
+	}
+
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.byUser[userID]++
+	first := h.byUser[userID] == 1
+	for _, chatID := range chatIDs {
+		h.subscribeLocked(c, chatID)
+	}
+
+	// Holding the lock guarantees nothing is broadcast between the replay
+	// snapshot and the client going live
+	ok = true
+	if replay && h.Replay == nil {
+		missed, ok = h.replayLocked(c, lastEventID)
+	}
+	upTo := h.seq
+	subs := make([]uint, 0, len(c.subs))
+	for chatID := range c.subs {
+		subs = append(subs, chatID)
+	}
+	h.mu.Unlock()
+
+	if replay && h.Replay != nil {
+		// Anything newer than upTo is already queued on the client
+		missed, ok = h.replayFromBus(subs, lastEventID, upTo)
+	}
+
+	if first && h.OnConnect != nil {
+		h.OnConnect(userID)
+	}
+	return c, missed, ok
+}
+
+// replayFromBus encodes the events Replay returns for chatIDs after
+// lastEventID, up to and including upTo
+func (h *Hub) replayFromBus(chatIDs []uint, lastEventID, upTo uint64) ([]frame, bool) {
+	if lastEventID > upTo {
+		// ID this instance has not seen yet, or from another deployment
+		return nil, false
+	}
+	if lastEventID == upTo {
+		return nil, true
+	}
+	events, ok := h.Replay(chatIDs, lastEventID, upTo)
+	if !ok {
+		return nil, false
+	}
+	missed := make([]frame, 0, len(events))
+	for _, evt := range events {
+		f, err := newFrame(evt)
+		if err != nil {
+			return nil, false
+		}
+		missed = append(missed, f)
+	}
+	return missed, true
+}
+
+// Connected reports whether userID holds a live connection to this hub
+func (h *Hub) Connected(userID uint) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.byUser[userID] > 0
+}
+
+// ConnectedUsers lists every user with a live connection to this hub
+func (h *Hub) ConnectedUsers() []uint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	userIDs := make([]uint, 0, len(h.byUser))
+	for userID := range h.byUser {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// replayLocked returns buffered frames newer than lastEventID for the chats c
+// is subscribed to
+func (h *Hub) replayLocked(c *Client, lastEventID uint64) ([]frame, bool) {
+	if lastEventID > h.seq || lastEventID+1 < h.first {
+		// ID from before a restart or from another server
+		return nil, false
+	}
+
+	var missed []frame
+	for chatID := range c.subs {
+		hist, ok := h.history[chatID]
+		if !ok {
+			continue
+		}
+		if hist.dropped > lastEventID {
+			// Some of the chat's events since then are gone
+			return nil, false
+		}
+		for _, f := range hist.frames {
+			if f.id > lastEventID {
+				missed = append(missed, f)
+			}
+		}
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].id < missed[j].id })
+	return missed, true
+}
+
+// Broadcast delivers evt to every client subscribed to evt.ChatID. Event IDs
+// are assigned by the bus, so Last-Event-ID values come from a single
+// sequence; events without one are delivered but cannot be replayed.
+func (h *Hub) Broadcast(evt Event) {
+	if evt.CreatedAt.IsZero() {
+		evt.CreatedAt = time.Now()
+	}
+
+	h.mu.Lock()
+	if evt.ephemeral() {
+		// Not replayable, so it must not move a client's Last-Event-ID
+		evt.ID = 0
+	} else if evt.ID > h.seq {
+		h.seq = evt.ID
+		if h.first == 0 {
+			h.first = evt.ID
+		}
+	}
+	if evt.Type == userSubscribed || evt.Type == userUnsubscribed {
+		h.applySubscriptionLocked(evt)
+		h.mu.Unlock()
+		return
+	}
+	f, err := newFrame(evt)
+	if err != nil {
+		h.mu.Unlock()
+		log.Printf("realtime: failed to encode %s event: %v", evt.Type, err)
+		return
+	}
+
+	if evt.ID != 0 && h.Replay == nil {
+		hist, ok := h.history[evt.ChatID]
+		if !ok {
+			hist = &chatHistory{}
+			h.history[evt.ChatID] = hist
+		}
+		hist.frames = append(hist.frames, f)
+		if n := len(hist.frames) - historySize; n > 0 {
+			hist.dropped = hist.frames[n-1].id
+			hist.frames = hist.frames[n:]
+		}
+	}
+
+	var slow []*Client
+	for c := range h.byChat[evt.ChatID] {
+		if evt.SkipUserID != 0 && c.UserID == evt.SkipUserID {
+			continue
+		}
+		if !c.enqueue(f) {
+			slow = append(slow, c)
+		}
+	}
+	h.mu.Unlock()
+
+	// Drop clients that cannot keep up rather than blocking every publisher
+	for _, c := range slow {
+		c.close()
+	}
+}
+
+// applySubscriptionLocked subscribes or unsubscribes the live connections of
+// the user named in evt
+func (h *Hub) applySubscriptionLocked(evt Event) {
+	// Data is a subscription when published here and raw JSON when it came
+	// through the outbox
+	var sub subscription
+	raw, err := json.Marshal(evt.Data)
+	if err == nil {
+		err = json.Unmarshal(raw, &sub)
+	}
+	if err != nil {
+		log.Printf("realtime: bad %s event: %v", evt.Type, err)
+		return
+	}
+
+	if evt.Type == userSubscribed {
+		h.subscribeUserLocked(sub.UserID, evt.ChatID)
+	} else {
+		h.unsubscribeUserLocked(sub.UserID, evt.ChatID)
+	}
+}
+
+func newFrame(evt Event) (frame, error) {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return frame{}, err
+	}
+	return frame{id: evt.ID, chatID: evt.ChatID, eventType: evt.Type, body: body}, nil
+}
+
+// Subscribe adds chatID to the client's subscriptions
+func (h *Hub) Subscribe(c *Client, chatID uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		h.subscribeLocked(c, chatID)
+	}
+}
+
+// Unsubscribe removes chatID from the client's subscriptions
+func (h *Hub) Unsubscribe(c *Client, chatID uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubscribeLocked(c, chatID)
+}
+
+// SubscribeUser subscribes every live connection of userID on this hub to
+// chatID. The package-level SubscribeUser reaches every instance.
+func (h *Hub) SubscribeUser(userID, chatID uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribeUserLocked(userID, chatID)
+}
+
+// UnsubscribeUser drops every subscription userID holds on chatID on this
+// hub. The package-level UnsubscribeUser reaches every instance.
+func (h *Hub) UnsubscribeUser(userID, chatID uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubscribeUserLocked(userID, chatID)
+}
+
+func (h *Hub) subscribeUserLocked(userID, chatID uint) {
+	for c := range h.clients {
+		if c.UserID == userID {
+			h.subscribeLocked(c, chatID)
+		}
+	}
+}
+
+func (h *Hub) unsubscribeUserLocked(userID, chatID uint) {
+	for c := range h.byChat[chatID] {
+		if c.UserID == userID {
+			h.unsubscribeLocked(c, chatID)
+		}
+	}
+}
+
+func (h *Hub) subscribeLocked(c *Client, chatID uint) {
+	set, ok := h.byChat[chatID]
+	if !ok {
+		set = make(map[*Client]struct{})
+		h.byChat[chatID] = set
+	}
+	set[c] = struct{}{}
+	c.subs[chatID] = struct{}{}
+}
+
+func (h *Hub) unsubscribeLocked(c *Client, chatID uint) {
+	if set, ok := h.byChat[chatID]; ok {
+		delete(set, c)
+		if len(set) == 0 {
+			delete(h.byChat, chatID)
+		}
+	}
+	delete(c.subs, chatID)
+}
+
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	if _, ok := h.clients[c]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	for chatID := range c.subs {
+		h.unsubscribeLocked(c, chatID)
+	}
+	delete(h.clients, c)
+	h.byUser[c.UserID]--
+	last := h.byUser[c.UserID] == 0
+	if last {
+		delete(h.byUser, c.UserID)
+	}
+	h.mu.Unlock()
+
+	if last && h.OnDisconnect != nil {
+		h.OnDisconnect(c.UserID)
+	}
+}
+
+// Send queues v as a JSON frame for this client only
+func (c *Client) Send(v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if !c.enqueue(frame{body: body}) {
+		c.close()
+	}
+}
+
+// enqueue hands a frame to the transport without blocking. It reports false
+// when the buffer is full; frames for an already closed client are dropped.
+func (c *Client) enqueue(f frame) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.closed {
+		return true
+	}
+	select {
+	case c.send <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+// close detaches the client from the hub and closes its send channel, which
+// tells the transport to shut the connection down
+func (c *Client) close() {
+	c.hub.unregister(c)
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
@@ -0,0 +1,49 @@
+package realtime
+
+import "testing"
+
+func TestReplayKeepsHistoryPerChat(t *testing.T) {
+	h := NewHub()
+	var id uint64
+	publish := func(evt Event) {
+		id++
+		evt.ID = id
+		h.Broadcast(evt)
+	}
+
+	publish(Event{Type: MessageCreated, ChatID: 2})
+	quiet := id
+
+	// A busy chat must not push the quiet chat's events out
+	for i := 0; i < historySize+10; i++ {
+		publish(Event{Type: MessageCreated, ChatID: 1})
+	}
+	publish(Event{Type: MessageUpdated, ChatID: 2})
+
+	c, missed, ok := h.attach(7, []uint{2}, quiet-1, true)
+	defer c.close()
+	if !ok {
+		t.Fatal("replay of the quiet chat reported missing events")
+	}
+	if len(missed) != 2 || missed[0].id != quiet || missed[1].eventType != MessageUpdated {
+		t.Fatalf("replayed %+v, want both chat 2 events in order", missed)
+	}
+
+	// The busy chat has dropped events newer than quiet
+	c2, missed, ok := h.attach(7, []uint{1, 2}, quiet, true)
+	defer c2.close()
+	if ok || missed != nil {
+		t.Fatalf("replay across a dropped event: ok=%v missed=%d", ok, len(missed))
+	}
+}
+
+func TestReplayRejectsIDFromBeforeStart(t *testing.T) {
+	h := NewHub()
+	h.Broadcast(Event{ID: 10, Type: MessageCreated, ChatID: 1})
+
+	c, _, ok := h.attach(7, []uint{1}, 8, true)
+	defer c.close()
+	if ok {
+		t.Fatal("ID from before the hub started was treated as replayable")
+	}
+}
@@ -0,0 +1,296 @@
+package realtime
+
+import (
+	"ChatApiServer/models"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// How often each instance polls the outbox for new rows
+	outboxPollInterval = 250 * time.Millisecond
+
+	// Rows read per poll
+	outboxBatchSize = 500
+
+	// How long a missing ID is waited for before it is treated as a gap left
+	// by a rolled back insert rather than a commit that has not landed yet
+	outboxGapGrace = 2 * time.Second
+
+	// Outbox rows older than this are deleted
+	outboxRetention  = time.Hour
+	outboxPruneEvery = time.Minute
+
+	// Signal rows (typing) older than this are deleted
+	signalRetention = time.Minute
+
+	// Most rows served for one Last-Event-ID replay; a client further behind
+	// is told to refetch instead
+	outboxReplayLimit = 1000
+)
+
+// MySQLBus shares events between server instances through an outbox table.
+// Publish inserts a row; every instance (including the publisher) polls the
+// table and hands new rows to its subscribers in ID order, so all instances
+// see the same event IDs. Ephemeral events go through a separate signal
+// table instead, so they are neither numbered nor replayed.
+type MySQLBus struct {
+	db *gorm.DB
+
+	mu       sync.RWMutex
+	handlers []func(Event)
+
+	lastID       uint64
+	lastSignalID uint64
+
+	// gapAt is the missing ID poll is holding back at, and gapSeen the local
+	// time it was first noticed. Timing gaps on this instance's clock keeps
+	// skew between publishers from skipping rows or stalling delivery.
+	gapAt   uint64
+	gapSeen time.Time
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMySQLBus starts polling the outbox on db. The newest row is delivered
+// first so the hub knows how far the outbox goes and can serve Last-Event-ID
+// replays for events raised before this instance started.
+func NewMySQLBus(db *gorm.DB) (*MySQLBus, error) {
+	var recent []uint64
+	if err := db.Model(&models.RealtimeEvent{}).
+		Order("id DESC").
+		Limit(1).
+		Pluck("id", &recent).Error; err != nil {
+		return nil, err
+	}
+
+	var signals []uint64
+	if err := db.Model(&models.RealtimeSignal{}).
+		Order("id DESC").
+		Limit(1).
+		Pluck("id", &signals).Error; err != nil {
+		return nil, err
+	}
+
+	b := &MySQLBus{
+		db:   db,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if len(signals) > 0 {
+		b.lastSignalID = signals[0]
+	}
+	if len(recent) > 0 {
+		// Start just before it, so rows pruned or rolled back earlier are
+		// not mistaken for a fresh gap
+		b.lastID = recent[0] - 1
+	}
+
+	go b.run()
+	return b, nil
+}
+
+// Publish writes evt to the outbox, or to the signal table if it is
+// ephemeral. It is delivered, to this instance too, on the next poll.
+func (b *MySQLBus) Publish(evt Event) error {
+	var payload []byte
+	if evt.Data != nil {
+		var err error
+		if payload, err = json.Marshal(evt.Data); err != nil {
+			return err
+		}
+	}
+	if evt.CreatedAt.IsZero() {
+		evt.CreatedAt = time.Now()
+	}
+	if evt.ephemeral() {
+		return b.db.Create(&models.RealtimeSignal{
+			Type:       evt.Type,
+			ChatID:     evt.ChatID,
+			Payload:    payload,
+			SkipUserID: evt.SkipUserID,
+			CreatedAt:  evt.CreatedAt,
+		}).Error
+	}
+	return b.db.Create(&models.RealtimeEvent{
+		Type:       evt.Type,
+		ChatID:     evt.ChatID,
+		Payload:    payload,
+		SkipUserID: evt.SkipUserID,
+		CreatedAt:  evt.CreatedAt,
+	}).Error
+}
+
+// Replay reads the outbox rows for chatIDs with IDs in (afterID, upToID]. It
+// reports false when rows after afterID may already have been pruned, or
+// there are more than outboxReplayLimit of them.
+func (b *MySQLBus) Replay(chatIDs []uint, afterID, upToID uint64) ([]Event, bool) {
+	if len(chatIDs) == 0 {
+		return nil, true
+	}
+
+	var oldest []uint64
+	if err := b.db.Model(&models.RealtimeEvent{}).
+		Order("id ASC").
+		Limit(1).
+		Pluck("id", &oldest).Error; err != nil {
+		log.Printf("realtime: outbox replay failed: %v", err)
+		return nil, false
+	}
+	if len(oldest) == 0 || afterID+1 < oldest[0] {
+		return nil, false
+	}
+
+	var rows []models.RealtimeEvent
+	if err := b.db.Where("id > ? AND id <= ? AND chat_id IN ?", afterID, upToID, chatIDs).
+		Where("type NOT IN ?", []string{userSubscribed, userUnsubscribed}).
+		Order("id ASC").
+		Limit(outboxReplayLimit + 1).
+		Find(&rows).Error; err != nil {
+		log.Printf("realtime: outbox replay failed: %v", err)
+		return nil, false
+	}
+	if len(rows) > outboxReplayLimit {
+		return nil, false
+	}
+
+	events := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, rowEvent(row))
+	}
+	return events, true
+}
+
+// Subscribe registers handler
+func (b *MySQLBus) Subscribe(handler func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Close stops polling
+func (b *MySQLBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return nil
+}
+
+func (b *MySQLBus) run() {
+	defer close(b.done)
+
+	poll := time.NewTicker(outboxPollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(outboxPruneEvery)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-poll.C:
+			if err := b.poll(); err != nil {
+				log.Printf("realtime: outbox poll failed: %v", err)
+			}
+			if err := b.pollSignals(); err != nil {
+				log.Printf("realtime: signal poll failed: %v", err)
+			}
+		case <-prune.C:
+			if err := b.db.Where("created_at < ?", time.Now().Add(-outboxRetention)).
+				Delete(&models.RealtimeEvent{}).Error; err != nil {
+				log.Printf("realtime: outbox prune failed: %v", err)
+			}
+			if err := b.db.Where("created_at < ?", time.Now().Add(-signalRetention)).
+				Delete(&models.RealtimeSignal{}).Error; err != nil {
+				log.Printf("realtime: signal prune failed: %v", err)
+			}
+		}
+	}
+}
+
+func (b *MySQLBus) poll() error {
+	var rows []models.RealtimeEvent
+	if err := b.db.Where("id > ?", b.lastID).
+		Order("id ASC").
+		Limit(outboxBatchSize).
+		Find(&rows).Error; err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, row := range rows {
+		// Auto-increment IDs can become visible out of order. Hold back at a
+		// fresh gap so a slower concurrent insert is not skipped forever.
+		if row.ID != b.lastID+1 {
+			if b.gapAt != b.lastID+1 {
+				b.gapAt = b.lastID + 1
+				b.gapSeen = time.Now()
+			}
+			if time.Since(b.gapSeen) < outboxGapGrace {
+				return nil
+			}
+		}
+
+		evt := rowEvent(row)
+		for _, handler := range b.handlers {
+			handler(evt)
+		}
+		b.lastID = row.ID
+	}
+	return nil
+}
+
+// pollSignals delivers new signal rows without an event ID. Unlike poll it
+// does not wait at gaps: a typing signal that commits late is simply missed.
+func (b *MySQLBus) pollSignals() error {
+	var rows []models.RealtimeSignal
+	if err := b.db.Where("id > ?", b.lastSignalID).
+		Order("id ASC").
+		Limit(outboxBatchSize).
+		Find(&rows).Error; err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, row := range rows {
+		evt := Event{
+			Type:       row.Type,
+			ChatID:     row.ChatID,
+			CreatedAt:  row.CreatedAt,
+			SkipUserID: row.SkipUserID,
+		}
+		if len(row.Payload) > 0 {
+			evt.Data = json.RawMessage(row.Payload)
+		}
+		for _, handler := range b.handlers {
+			handler(evt)
+		}
+		b.lastSignalID = row.ID
+	}
+	return nil
+}
+
+func rowEvent(row models.RealtimeEvent) Event {
+	evt := Event{
+		ID:         row.ID,
+		Type:       row.Type,
+		ChatID:     row.ChatID,
+		CreatedAt:  row.CreatedAt,
+		SkipUserID: row.SkipUserID,
+	}
+	if len(row.Payload) > 0 {
+		evt.Data = json.RawMessage(row.Payload)
+	}
+	return evt
+}
@@ -0,0 +1,201 @@
+package realtime
+
+import (
+	"ChatApiServer/models"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openOutbox opens a throwaway database standing in for the shared MySQL
+// server
+func openOutbox(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/outbox.db"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.RealtimeEvent{}, &models.RealtimeSignal{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// startInstance wires a fresh hub to its own bus on db, like one server
+// process started with EVENT_BUS=mysql
+func startInstance(t *testing.T, db *gorm.DB) (*Hub, *MySQLBus) {
+	t.Helper()
+	hub := NewHub()
+	bus, err := NewMySQLBus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.Replay = bus.Replay
+	bus.Subscribe(hub.Broadcast)
+	t.Cleanup(func() { bus.Close() })
+	return hub, bus
+}
+
+func receive(t *testing.T, c *Client) frame {
+	t.Helper()
+	select {
+	case f := <-c.send:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return frame{}
+	}
+}
+
+func TestMySQLBusTwoInstances(t *testing.T) {
+	db := openOutbox(t)
+	hubA, busA := startInstance(t, db)
+	hubB, _ := startInstance(t, db)
+
+	onA, _, _ := hubA.attach(1, []uint{7}, 0, false)
+	onB, _, _ := hubB.attach(2, []uint{7}, 0, false)
+
+	// Published on A, delivered by both instances with the outbox row's ID
+	if err := busA.Publish(Event{Type: MessageCreated, ChatID: 7, Data: map[string]string{"text": "hi"}}); err != nil {
+		t.Fatal(err)
+	}
+	fa, fb := receive(t, onA), receive(t, onB)
+	if fa.id == 0 || fa.id != fb.id {
+		t.Fatalf("instances disagree on event ID: %d vs %d", fa.id, fb.id)
+	}
+	var evt Event
+	if err := json.Unmarshal(fb.body, &evt); err != nil || evt.Type != MessageCreated {
+		t.Fatalf("unexpected frame %s", fb.body)
+	}
+
+	// A client that saw the first event on B can resume on A
+	busA.Publish(Event{Type: MessageUpdated, ChatID: 7})
+	second := receive(t, onA)
+	moved, missed, ok := hubA.attach(2, []uint{7}, fb.id, true)
+	defer moved.close()
+	if !ok || len(missed) != 1 || missed[0].id != second.id {
+		t.Fatalf("replay on the other instance: ok=%v missed=%d", ok, len(missed))
+	}
+}
+
+func TestMySQLBusReplayFromOutbox(t *testing.T) {
+	db := openOutbox(t)
+	hub, bus := startInstance(t, db)
+	watcher, _, _ := hub.attach(1, []uint{7, 8}, 0, false)
+
+	for _, chatID := range []uint{7, 8, 7} {
+		bus.Publish(Event{Type: MessageCreated, ChatID: chatID})
+	}
+	first := receive(t, watcher)
+	receive(t, watcher)
+	last := receive(t, watcher)
+
+	// Only the subscribed chat's rows come back, from the table
+	c, missed, ok := hub.attach(2, []uint{7}, first.id, true)
+	defer c.close()
+	if !ok || len(missed) != 1 || missed[0].id != last.id {
+		t.Fatalf("replay: ok=%v missed=%+v", ok, missed)
+	}
+
+	// Once the rows after the client's ID are pruned it has to refetch
+	db.Where("id <= ?", first.id+1).Delete(&models.RealtimeEvent{})
+	c2, _, ok := hub.attach(2, []uint{7}, first.id, true)
+	defer c2.close()
+	if ok {
+		t.Fatal("replay across pruned rows was reported complete")
+	}
+}
+
+func TestMySQLBusSubscribeUserReachesOtherInstances(t *testing.T) {
+	db := openOutbox(t)
+	_, busA := startInstance(t, db)
+	hubB, _ := startInstance(t, db)
+	onB, _, _ := hubB.attach(2, nil, 0, false)
+
+	// Added to chat 7 through instance A while connected to B
+	saved := Bus
+	Bus = busA
+	defer func() { Bus = saved }()
+	SubscribeUser(2, 7)
+	busA.Publish(Event{Type: MessageCreated, ChatID: 7})
+	if f := receive(t, onB); f.eventType != MessageCreated {
+		t.Fatalf("expected the chat's event on B, got %s", f.body)
+	}
+
+	// B handles rows in order, so once chat 9's event arrives there the
+	// unsubscribe and the chat 7 event before it have been handled too
+	marker, _, _ := hubB.attach(3, []uint{9}, 0, false)
+	UnsubscribeUser(2, 7)
+	busA.Publish(Event{Type: MessageCreated, ChatID: 7})
+	busA.Publish(Event{Type: MessageCreated, ChatID: 9})
+	receive(t, marker)
+	hubB.mu.Lock()
+	_, subscribed := onB.subs[7]
+	hubB.mu.Unlock()
+	if subscribed || len(onB.send) != 0 {
+		t.Fatal("user is still subscribed on B after being removed")
+	}
+}
+
+func TestTypingReachesOtherInstancesWithoutOutbox(t *testing.T) {
+	db := openOutbox(t)
+	_, busA := startInstance(t, db)
+	hubB, _ := startInstance(t, db)
+
+	c, _, _ := hubB.attach(1, []uint{9}, 0, false)
+	defer c.close()
+
+	busA.Publish(Event{Type: TypingStart, ChatID: 9, Data: map[string]uint{"user_id": 2}})
+	if f := receive(t, c); f.id != 0 || f.eventType != TypingStart {
+		t.Fatalf("expected an unnumbered typing.start, got %q id %d", f.eventType, f.id)
+	}
+	var rows int64
+	db.Model(&models.RealtimeEvent{}).Count(&rows)
+	if rows != 0 {
+		t.Fatalf("typing event written to the outbox (%d rows)", rows)
+	}
+}
+
+func TestMySQLBusGapUsesLocalClock(t *testing.T) {
+	tests := []struct {
+		name string
+		skew time.Duration // publisher clock relative to ours
+	}{
+		{"publisher ahead", time.Hour},
+		{"publisher behind", -time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openOutbox(t)
+			// Polled by hand, so no background goroutine is started
+			b := &MySQLBus{db: db}
+			var delivered []uint64
+			b.Subscribe(func(evt Event) { delivered = append(delivered, evt.ID) })
+
+			// ID 2 is missing, as if its insert had not committed yet
+			at := time.Now().Add(tt.skew)
+			db.Create(&models.RealtimeEvent{ID: 1, Type: MessageCreated, CreatedAt: at})
+			db.Create(&models.RealtimeEvent{ID: 3, Type: MessageCreated, CreatedAt: at})
+
+			if err := b.poll(); err != nil {
+				t.Fatal(err)
+			}
+			if len(delivered) != 1 {
+				t.Fatalf("fresh gap should hold back row 3, delivered %v", delivered)
+			}
+
+			// Once the gap has been open for the grace period here, move on
+			b.gapSeen = time.Now().Add(-outboxGapGrace)
+			if err := b.poll(); err != nil {
+				t.Fatal(err)
+			}
+			if len(delivered) != 2 || delivered[1] != 3 {
+				t.Fatalf("expected row 3 after the grace period, delivered %v", delivered)
+			}
+		})
+	}
+}
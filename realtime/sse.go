@@ -0,0 +1,84 @@
+package realtime
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Comment lines are sent this often so proxies keep the stream open
+	sseHeartbeat = 25 * time.Second
+
+	// Reconnect delay suggested to EventSource clients, in milliseconds
+	sseRetry = 3000
+)
+
+// ServeSSE streams events for chatIDs to w as Server-Sent Events until the
+// client goes away. A Last-Event-ID header (or last_event_id query parameter)
+// replays buffered events the client missed; when that is no longer possible
+// a "reset" event is sent first so the client knows to refetch.
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request, userID uint, chatIDs []uint) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, `{"error":"Streaming unsupported"}`, http.StatusInternalServerError)
+		return
+	}
+
+	lastEventIDStr := r.Header.Get("Last-Event-ID")
+	if lastEventIDStr == "" {
+		lastEventIDStr = r.URL.Query().Get("last_event_id")
+	}
+	lastEventID, err := strconv.ParseUint(lastEventIDStr, 10, 64)
+	replay := err == nil
+
+	c, missed, complete := h.attach(userID, chatIDs, lastEventID, replay)
+	defer c.close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	if !complete {
+		fmt.Fprintf(w, "event: %s\ndata: {\"type\":\"%s\"}\n\n", Reset, Reset)
+	}
+	for _, f := range missed {
+		writeSSE(w, f)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case f, ok := <-c.send:
+			if !ok {
+				// Dropped by the hub as too slow; the client will reconnect
+				// with its Last-Event-ID and catch up
+				return
+			}
+			writeSSE(w, f)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, f frame) {
+	if f.id != 0 {
+		fmt.Fprintf(w, "id: %d\n", f.id)
+	}
+	if f.eventType != "" {
+		fmt.Fprintf(w, "event: %s\n", f.eventType)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", f.body)
+}
@@ -0,0 +1,114 @@
+package realtime
+
+import (
+	"sync"
+	"time"
+)
+
+// Typing event types
+const (
+	TypingStart = "typing.start"
+	TypingStop  = "typing.stop"
+)
+
+// TypingTTL is how long a typing.start stays active without being refreshed
+const TypingTTL = 6 * time.Second
+
+// TypingStore keeps short-lived "user is typing" state per chat. The state
+// lives in memory on the instance that received the signal; the typing events
+// it produces reach every instance through the bus but are never numbered or
+// replayed. Each typing.start carries expires_in_ms and receivers drop the
+// indicator after it on their own, so a typing.stop that never arrives (e.g.
+// the user's next request was served by another instance) only leaves it up
+// until the TTL.
+type TypingStore struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	byChat map[uint]map[uint]*typingEntry
+
+	// OnExpire is called when a typing state times out without a stop
+	OnExpire func(chatID, userID uint)
+}
+
+// Typing is the store used by the HTTP and WebSocket handlers
+var Typing = NewTypingStore(TypingTTL)
+
+// NewTypingStore creates a store whose entries expire after ttl
+func NewTypingStore(ttl time.Duration) *TypingStore {
+	return &TypingStore{
+		ttl:    ttl,
+		byChat: make(map[uint]map[uint]*typingEntry),
+	}
+}
+
+type typingEntry struct {
+	timer *time.Timer
+}
+
+// Start marks userID as typing in chatID, or extends an existing entry. It
+// reports whether the user was not already typing.
+func (s *TypingStore) Start(chatID, userID uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, ok := s.byChat[chatID]
+	if !ok {
+		users = make(map[uint]*typingEntry)
+		s.byChat[chatID] = users
+	}
+	if entry, ok := users[userID]; ok {
+		entry.timer.Reset(s.ttl)
+		return false
+	}
+
+	entry := &typingEntry{}
+	entry.timer = time.AfterFunc(s.ttl, func() {
+		if s.remove(chatID, userID, entry) && s.OnExpire != nil {
+			s.OnExpire(chatID, userID)
+		}
+	})
+	users[userID] = entry
+	return true
+}
+
+// Stop clears userID's typing state in chatID. It reports whether the user
+// was typing.
+func (s *TypingStore) Stop(chatID, userID uint) bool {
+	s.mu.Lock()
+	entry, ok := s.byChat[chatID][userID]
+	if ok {
+		entry.timer.Stop()
+	}
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	return s.remove(chatID, userID, entry)
+}
+
+// Users lists who is currently typing in chatID
+func (s *TypingStore) Users(chatID uint) []uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userIDs := make([]uint, 0, len(s.byChat[chatID]))
+	for userID := range s.byChat[chatID] {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// remove deletes the entry only if it is still the current one, so a stale
+// expiry cannot clear a newer start
+func (s *TypingStore) remove(chatID, userID uint, entry *typingEntry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := s.byChat[chatID]
+	if users[userID] != entry {
+		return false
+	}
+	delete(users, userID)
+	if len(users) == 0 {
+		delete(s.byChat, chatID)
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package realtime
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a frame to the peer
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong from the peer
+	pongWait = 60 * time.Second
+
+	// Send pings at this interval; must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// Largest frame accepted from a client
+	maxMessageSize = 4096
+)
+
+// Serve registers conn for userID, subscribes it to chatIDs and pumps frames
+// until the peer disconnects. It blocks for the lifetime of the connection.
+func (h *Hub) Serve(conn *websocket.Conn, userID uint, chatIDs []uint) {
+	c, _, _ := h.attach(userID, chatIDs, 0, false)
+
+	go c.writePump(conn)
+	c.readPump(conn)
+}
+
+func (c *Client) readPump(conn *websocket.Conn) {
+	defer func() {
+		c.close()
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var msg ClientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("realtime: connection for user %d closed: %v", c.UserID, err)
+			}
+			return
+		}
+		// Any client frame counts as proof of life
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.handle(msg)
+	}
+}
+
+func (c *Client) handle(msg ClientMessage) {
+	switch msg.Action {
+	case ActionPing:
+		c.Send(map[string]string{"type": "pong"})
+	case ActionSubscribe:
+		if c.hub.Authorize == nil || !c.hub.Authorize(c.UserID, msg.ChatID) {
+			c.Send(map[string]interface{}{"type": "error", "chat_id": msg.ChatID, "error": "Not a member of this chat"})
+			return
+		}
+		c.hub.Subscribe(c, msg.ChatID)
+		c.Send(map[string]interface{}{"type": "subscribed", "chat_id": msg.ChatID})
+	case ActionUnsubscribe:
+		c.hub.Unsubscribe(c, msg.ChatID)
+		c.Send(map[string]interface{}{"type": "unsubscribed", "chat_id": msg.ChatID})
+	default:
+		if c.hub.HandleAction == nil {
+			c.Send(map[string]string{"type": "error", "error": "Unknown action"})
+			return
+		}
+		if err := c.hub.HandleAction(c.UserID, msg); err != nil {
+			c.Send(map[string]interface{}{"type": "error", "chat_id": msg.ChatID, "error": err.Error()})
+		}
+	}
+}
+
+func (c *Client) writePump(conn *websocket.Conn) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case f, ok := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// Hub closed the channel
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, f.body); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when a blob does not exist
+var ErrNotFound = errors.New("storage: blob not found")
+
+// BlobStore keeps uploaded file contents under opaque keys. Metadata lives
+// in the database; a store only holds bytes.
+type BlobStore interface {
+	// Put writes the contents of r under key, replacing any existing blob,
+	// and returns the number of bytes written
+	Put(key string, r io.Reader) (int64, error)
+
+	// Open returns a reader for the blob stored under key
+	Open(key string) (io.ReadCloser, error)
+
+	// Delete removes the blob under key; deleting a missing blob is not an
+	// error
+	Delete(key string) error
+}
+
+// Default is the store used by the attachment handlers. It is set by main at
+// startup.
+var Default BlobStore